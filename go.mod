@@ -1,6 +1,6 @@
 module github.com/flaviostutz/wfs-eye
 
-go 1.12
+go 1.15
 
 require (
 	github.com/flaviostutz/wfs-tiler v0.0.0-20190702021725-7579beb01bc1
@@ -9,6 +9,13 @@ require (
 	github.com/itsjamie/gin-cors v0.0.0-20160420130702-97b4a9da7933
 	github.com/paulmach/orb v0.1.3
 	github.com/paulsmith/gogeos v0.1.2
+	github.com/prometheus/client_golang v1.0.0
 	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce
+	gopkg.in/square/go-jose.v2 v2.3.1
 )
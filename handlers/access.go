@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+//ViewAccess restricts who can get the features of a view. Views without it are public.
+//Callers are allowed if they send one of 'apiKeys', belong to one of 'groups' or
+//have a JWT with all 'claims'. API keys are stored and shown only as SHA-256 hashes
+type ViewAccess struct {
+	APIKeys []string     `json:"apiKeys,omitempty" bson:"apiKeys,omitempty"`
+	Groups  []string     `json:"groups,omitempty" bson:"groups,omitempty"`
+	Claims  []ClaimMatch `json:"claims,omitempty" bson:"claims,omitempty"`
+}
+
+//ClaimMatch requires a JWT claim to be (or to contain, for arrays) value
+type ClaimMatch struct {
+	Claim string `json:"claim" bson:"claim"`
+	Value string `json:"value" bson:"value"`
+}
+
+//apiKeyHashPrefix marks the API keys of 'access' that are already hashed
+const apiKeyHashPrefix = "sha256:"
+
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return apiKeyHashPrefix + hex.EncodeToString(h[:])
+}
+
+//hashedAPIKeys returns 'apiKeys' with the keys not yet hashed replaced by their hashes.
+//Views stored before keys were hashed may still have plain keys
+func (a *ViewAccess) hashedAPIKeys() []string {
+	if a.APIKeys == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(a.APIKeys))
+	for _, k := range a.APIKeys {
+		if !strings.HasPrefix(k, apiKeyHashPrefix) {
+			k = hashAPIKey(k)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+//withHashedAPIKeys returns a copy of the view whose API keys are hashed, so that they can be stored or shown
+func withHashedAPIKeys(view View) View {
+	if view.Access != nil {
+		a := *view.Access
+		a.APIKeys = a.hashedAPIKeys()
+		view.Access = &a
+	}
+	return view
+}
+
+//consumer holds the credentials sent by a caller of the WFS API
+type consumer struct {
+	apiKey string
+	//claims is nil if no valid JWT was sent
+	claims map[string]interface{}
+}
+
+func (a *ViewAccess) validate() error {
+	if len(a.APIKeys) == 0 && len(a.Groups) == 0 && len(a.Claims) == 0 {
+		return fmt.Errorf("'access' must have 'apiKeys', 'groups' or 'claims'. Remove it to make the view public")
+	}
+	for _, cm := range a.Claims {
+		if cm.Claim == "" {
+			return fmt.Errorf("'claim' is required in 'access.claims'")
+		}
+	}
+	if (len(a.Groups) > 0 || len(a.Claims) > 0) && jwtAuth == nil {
+		logrus.Warnf("View access uses JWT groups or claims but 'jwt-jwks-file' is not set. Only API keys will be accepted")
+	}
+	return nil
+}
+
+//requestConsumer gets the API key and JWT claims of a request. An invalid JWT is an error
+func requestConsumer(r *http.Request) (consumer, error) {
+	cons := consumer{apiKey: r.Header.Get("X-API-Key")}
+	token := bearerToken(r)
+	if token == "" || jwtAuth == nil {
+		return cons, nil
+	}
+	claims, err := jwtAuth.validate(token)
+	if err != nil {
+		return cons, err
+	}
+	cons.claims = claims
+	return cons, nil
+}
+
+func (cons consumer) anonymous() bool {
+	return cons.apiKey == "" && cons.claims == nil
+}
+
+//validAPIKey tells whether the consumer sent one of the 'apiKeys' of the view
+func (cons consumer) validAPIKey(view View) bool {
+	if cons.apiKey == "" || view.Access == nil {
+		return false
+	}
+	return matchesSecret(view.Access.hashedAPIKeys(), hashAPIKey(cons.apiKey))
+}
+
+//canRead tells whether the consumer is allowed by the view access policy
+func (cons consumer) canRead(view View) bool {
+	a := view.Access
+	if a == nil {
+		return true
+	}
+	if cons.validAPIKey(view) {
+		return true
+	}
+	if cons.claims == nil {
+		return false
+	}
+	for _, g := range jwtAuth.groups(cons.claims) {
+		if containsString(a.Groups, g) {
+			return true
+		}
+	}
+	if len(a.Claims) == 0 {
+		return false
+	}
+	for _, cm := range a.Claims {
+		if !containsString(claimStrings(cons.claims, cm.Claim), cm.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+//canReadChain tells whether the consumer is allowed by the access policies of all views of a chain.
+//A view over a restricted view can't expose its features to more consumers than the restricted view
+func (cons consumer) canReadChain(chain []View) bool {
+	for _, view := range chain {
+		if !cons.canRead(view) {
+			return false
+		}
+	}
+	return true
+}
+
+//restrictedChain tells whether any view of a chain has an access policy
+func restrictedChain(chain []View) bool {
+	for _, view := range chain {
+		if view.Access != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//viewChainIn follows the chain of view name in views, as resolveViewChain does with the view store
+func viewChainIn(views map[string]View, name string) []View {
+	chain := make([]View, 0)
+	for {
+		view, ok := views[name]
+		if !ok || len(chain) > len(views) {
+			return chain
+		}
+		chain = append(chain, view)
+		name = view.Collection
+	}
+}
+
+//published tells whether an upstream collection can be queried directly, without a view
+func published(collection string) bool {
+	for _, p := range opt.PublishedCollections {
+		ok, _ := path.Match(p, collection)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+//authorizeCollection checks the access policies of all views of the chain of collection if it is a view or whether
+//it is published if it isn't. If the caller is not allowed, the response is sent and false is returned
+func authorizeCollection(c *gin.Context, collection string) bool {
+	chain, _, err := resolveViewChain(c.Request.Context(), collection)
+	if err == nil && len(chain) == 0 {
+		if published(collection) {
+			return true
+		}
+		//unpublished upstream collections are reported as missing so that their names aren't disclosed
+		c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("Collection %s not found", collection)})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error getting view. err=%s", err)})
+		logrus.Warnf("Error getting view %s. err=%s", collection, err)
+		return false
+	}
+	c.Set("view", collection)
+	if !restrictedChain(chain) {
+		return true
+	}
+	if !checkAuthFailures(c) {
+		return false
+	}
+
+	cons, err := requestConsumer(c.Request)
+	if err != nil {
+		logrus.Debugf("Consumer authentication failed. err=%s", err)
+		countAuthFailure(c)
+		sendUnauthorized(c, err.Error())
+		return false
+	}
+	if cons.canReadChain(chain) {
+		return true
+	}
+	if cons.anonymous() {
+		sendUnauthorized(c, fmt.Sprintf("Credentials are required for collection %s", collection))
+		return false
+	}
+	countAuthFailure(c)
+	c.JSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("Access to collection %s denied", collection)})
+	return false
+}
+
+func sendUnauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="wfs-eye"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"message": message})
+}
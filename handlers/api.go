@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var errCollectionNotFound = errors.New("Collection not found")
+
+var conformanceClasses = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/oas30",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs",
+}
+
+//Link is a hypermedia link as used by OGC API Features documents
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+func (h *HTTPServer) setupAPIHandlers(opt Options) {
+	h.router.GET("/", getLandingPage())
+	h.router.GET("/conformance", getConformance())
+	h.router.GET("/api", getAPI())
+}
+
+func getLandingPage() func(*gin.Context) {
+	return func(c *gin.Context) {
+		base := baseURL(c)
+		//the upstream host is never disclosed to clients
+		c.JSON(http.StatusOK, gin.H{
+			"title":       "wfs-eye",
+			"description": "Views over the collections of WFS 3.0 servers",
+			"links": []Link{
+				{Href: base + "/", Rel: "self", Type: "application/json", Title: "This document"},
+				{Href: base + "/api", Rel: "service-desc", Type: "application/vnd.oai.openapi+json;version=3.0", Title: "The API definition"},
+				{Href: base + "/conformance", Rel: "conformance", Type: "application/json", Title: "Conformance classes implemented by this server"},
+				{Href: base + "/collections", Rel: "data", Type: "application/json", Title: "Views and collections"},
+			},
+		})
+	}
+}
+
+func getConformance() func(*gin.Context) {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"conformsTo": conformanceClasses})
+	}
+}
+
+func getAPI() func(*gin.Context) {
+	return func(c *gin.Context) {
+		cons, err := requestConsumer(c.Request)
+		if err != nil {
+			sendUnauthorized(c, err.Error())
+			return
+		}
+		names, err := collectionNames(c.Request.Context(), cons)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error listing collections. err=%s", err)})
+			logrus.Warnf("Error listing collections. err=%s", err)
+			return
+		}
+
+		collectionID := gin.H{
+			"name":        "collectionId",
+			"in":          "path",
+			"required":    true,
+			"description": "Name of a view or of an upstream collection",
+			"schema":      gin.H{"type": "string", "enum": names},
+		}
+		featureID := gin.H{
+			"name":        "featureId",
+			"in":          "path",
+			"required":    true,
+			"description": "Id of the feature in the upstream collection",
+			"schema":      gin.H{"type": "string"},
+		}
+
+		params := gin.H{
+			"collectionId": collectionID,
+			"featureId":    featureID,
+			"bbox": gin.H{
+				"name":        "bbox",
+				"in":          "query",
+				"required":    false,
+				"description": "Only features that intersect the bounding box (west,north,east,south). Clipped to the view 'maxBbox'",
+				"style":       "form",
+				"explode":     false,
+				"schema":      gin.H{"type": "array", "minItems": 4, "maxItems": 4, "items": gin.H{"type": "number"}},
+			},
+			"bbox-crs": gin.H{
+				"name":        "bbox-crs",
+				"in":          "query",
+				"required":    false,
+				"description": "CRS of 'bbox'. Defaults to CRS84",
+				"schema":      gin.H{"type": "string", "format": "uri", "enum": crsURIs(supportedCRS)},
+			},
+			"crs": gin.H{
+				"name":        "crs",
+				"in":          "query",
+				"required":    false,
+				"description": "CRS of the returned geometries. Defaults to CRS84. Views may allow only some of them",
+				"schema":      gin.H{"type": "string", "format": "uri", "enum": crsURIs(supportedCRS)},
+			},
+			"zoom": gin.H{
+				"name":        "zoom",
+				"in":          "query",
+				"required":    false,
+				"description": "Web map zoom level the features are shown at. Geometries are simplified to the size of a pixel at this zoom",
+				"schema":      gin.H{"type": "number", "minimum": 0, "maximum": maxZoom},
+			},
+			"properties": gin.H{
+				"name":        "properties",
+				"in":          "query",
+				"required":    false,
+				"description": "Comma separated properties returned in each feature. Defaults to all properties the collection exposes",
+				"style":       "form",
+				"explode":     false,
+				"schema":      gin.H{"type": "array", "items": gin.H{"type": "string"}},
+			},
+			"limit": gin.H{
+				"name":        "limit",
+				"in":          "query",
+				"required":    false,
+				"description": "Max number of features returned. Limited to the view 'maxLimit'",
+				"schema":      gin.H{"type": "integer", "minimum": 1},
+			},
+			"offset": gin.H{
+				"name":        "offset",
+				"in":          "query",
+				"required":    false,
+				"description": "Paging offset forwarded to the upstream WFS. Prefer following the 'next' link of the response",
+				"schema":      gin.H{"type": "integer", "minimum": 0},
+			},
+			"time": gin.H{
+				"name":        "time",
+				"in":          "query",
+				"required":    false,
+				"description": "Date or interval (2019-01-01/2020-06-30, 2019-01-01/ or /2020-06-30). Clipped to the view 'maxTimeRange'",
+				"schema":      gin.H{"type": "string"},
+			},
+		}
+		jsonResponse := func(description string) gin.H {
+			return gin.H{
+				"description": description,
+				"content":     gin.H{"application/json": gin.H{"schema": gin.H{"type": "object"}}},
+			}
+		}
+
+		c.Header("Content-Type", "application/vnd.oai.openapi+json;version=3.0")
+		c.JSON(http.StatusOK, gin.H{
+			"openapi": "3.0.2",
+			"info": gin.H{
+				"title":       "wfs-eye",
+				"description": "OGC API Features proxy that serves views over the collections of an upstream WFS 3.0 server",
+				"version":     "1.0.0",
+			},
+			"servers": []gin.H{{"url": baseURL(c)}},
+			"components": gin.H{
+				"parameters": params,
+			},
+			"paths": gin.H{
+				"/": gin.H{"get": gin.H{
+					"summary":     "Landing page",
+					"operationId": "getLandingPage",
+					"responses":   gin.H{"200": jsonResponse("Links to the API capabilities")},
+				}},
+				"/conformance": gin.H{"get": gin.H{
+					"summary":     "Conformance classes implemented by this server",
+					"operationId": "getConformanceDeclaration",
+					"responses":   gin.H{"200": jsonResponse("Conformance classes")},
+				}},
+				"/api": gin.H{"get": gin.H{
+					"summary":     "This API definition",
+					"operationId": "getAPI",
+					"responses":   gin.H{"200": jsonResponse("OpenAPI 3.0 document")},
+				}},
+				"/collections": gin.H{"get": gin.H{
+					"summary":     "Views and upstream collections",
+					"operationId": "getCollections",
+					"responses":   gin.H{"200": jsonResponse("Collections document")},
+				}},
+				"/collections/{collectionId}": gin.H{"get": gin.H{
+					"summary":     "Metadata of a view or upstream collection",
+					"operationId": "describeCollection",
+					"parameters":  []gin.H{{"$ref": "#/components/parameters/collectionId"}},
+					"responses": gin.H{
+						"200": jsonResponse("Collection document. The extent of a view is the intersection of the restrictions of all views in its chain"),
+						"404": jsonResponse("Collection not found"),
+					},
+				}},
+				"/collections/{collectionId}/items": gin.H{"get": gin.H{
+					"summary":     "Features of a view or upstream collection",
+					"operationId": "getFeatures",
+					"parameters": []gin.H{
+						{"$ref": "#/components/parameters/collectionId"},
+						{"$ref": "#/components/parameters/bbox"},
+						{"$ref": "#/components/parameters/bbox-crs"},
+						{"$ref": "#/components/parameters/crs"},
+						{"$ref": "#/components/parameters/zoom"},
+						{"$ref": "#/components/parameters/properties"},
+						{"$ref": "#/components/parameters/limit"},
+						{"$ref": "#/components/parameters/time"},
+						{"$ref": "#/components/parameters/offset"},
+					},
+					"responses": gin.H{"200": gin.H{
+						"description": "GeoJSON FeatureCollection",
+						"content":     gin.H{"application/geo+json": gin.H{"schema": gin.H{"type": "object"}}},
+					}},
+				}},
+				"/collections/{collectionId}/items/{featureId}": gin.H{"get": gin.H{
+					"summary":     "A single feature of a view or upstream collection",
+					"operationId": "getFeature",
+					"parameters": []gin.H{
+						{"$ref": "#/components/parameters/collectionId"},
+						{"$ref": "#/components/parameters/featureId"},
+						{"$ref": "#/components/parameters/crs"},
+						{"$ref": "#/components/parameters/zoom"},
+						{"$ref": "#/components/parameters/properties"},
+					},
+					"responses": gin.H{
+						"200": gin.H{
+							"description": "GeoJSON Feature",
+							"content":     gin.H{"application/geo+json": gin.H{"schema": gin.H{"type": "object"}}},
+						},
+						"404": jsonResponse("Feature not found or outside the view restrictions"),
+					},
+				}},
+			},
+		})
+	}
+}
+
+//collectionNames returns the names of the views the consumer can read followed by the ids of the upstream collections
+func collectionNames(ctx context.Context, cons consumer) ([]string, error) {
+	views, err := opt.ViewStore.List()
+	if err != nil {
+		return nil, err
+	}
+	viewsByName := make(map[string]View)
+	viewNames := make([]string, 0)
+	for _, v := range views {
+		if v.Name == nil {
+			continue
+		}
+		viewsByName[*v.Name] = v
+		viewNames = append(viewNames, *v.Name)
+	}
+	names := make([]string, 0)
+	for _, name := range viewNames {
+		if cons.canReadChain(viewChainIn(viewsByName, name)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	//the API is still usable for views if upstreams are down
+	for _, uc := range fetchAllUpstreamCollections(ctx) {
+		id := uc["id"].(string)
+		//a view with the same name hides the upstream collection even if the consumer can't read it
+		if !containsString(viewNames, id) && published(id) {
+			names = append(names, id)
+		}
+	}
+	return names, nil
+}
+
+//fetchUpstreamCollections returns the collection documents from the upstream WFS as is
+func fetchUpstreamCollections(ctx context.Context, u *upstreamClient) ([]map[string]interface{}, error) {
+	q := fmt.Sprintf("%s/collections", u.url)
+	logrus.Debugf("WFS query: %s", q)
+	resp, err := u.Get(ctx, q)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading WFS service response. err=%s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WFS invocation error. status=%d. body=%s", resp.StatusCode, string(data))
+	}
+
+	var cs struct {
+		Collections []map[string]interface{} `json:"collections"`
+	}
+	err = json.Unmarshal(data, &cs)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+	}
+	return cs.Collections, nil
+}
+
+//fetchUpstreamCollection returns the collection document from the upstream WFS as is
+func fetchUpstreamCollection(ctx context.Context, collectionName string) (map[string]interface{}, error) {
+	u, name := upstreamFor(collectionName)
+	q := fmt.Sprintf("%s/collections/%s", u.url, name)
+	logrus.Debugf("WFS query: %s", q)
+	resp, err := u.Get(ctx, q)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading WFS service response. err=%s", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCollectionNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WFS invocation error. status=%d. body=%s", resp.StatusCode, string(data))
+	}
+
+	col := make(map[string]interface{})
+	err = json.Unmarshal(data, &col)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+	}
+	return col, nil
+}
+
+//upstreamCollectionID supports both 'id' (OGC API Features) and 'name' (WFS 3.0 drafts)
+func upstreamCollectionID(uc map[string]interface{}) string {
+	id, ok := uc["id"].(string)
+	if ok && id != "" {
+		return id
+	}
+	name, _ := uc["name"].(string)
+	return name
+}
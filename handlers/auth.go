@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+//Roles of the /views admin API. 'write' includes 'read'
+const (
+	roleRead  = "read"
+	roleWrite = "write"
+)
+
+var viewsAuth *viewsAuthenticator
+
+//ViewsAuthOptions configures who can use the /views admin API
+type ViewsAuthOptions struct {
+	//Type is 'none', 'token', 'basic' or 'jwt'
+	Type string
+	//ReadTokens and WriteTokens are static bearer tokens for type 'token'
+	ReadTokens  []string
+	WriteTokens []string
+	//ReadUsers and WriteUsers are 'username:password' pairs for type 'basic'
+	ReadUsers  []string
+	WriteUsers []string
+	//ReadRoles and WriteRoles are values of the JWT roles claim for type 'jwt'
+	ReadRoles  []string
+	WriteRoles []string
+}
+
+//viewsAuthenticator finds the role of callers of the /views admin API
+type viewsAuthenticator struct {
+	typ   string
+	read  []string
+	write []string
+	jwt   *jwtValidator
+}
+
+func newViewsAuthenticator(opt Options) (*viewsAuthenticator, error) {
+	ao := opt.ViewsAuth
+	a := &viewsAuthenticator{typ: ao.Type}
+	switch ao.Type {
+	case "", "none":
+		a.typ = "none"
+		logrus.Warnf("The /views API is not protected. Anyone can change views. Use 'views-auth' to require credentials")
+	case "token":
+		a.read, a.write = ao.ReadTokens, ao.WriteTokens
+	case "basic":
+		a.read, a.write = ao.ReadUsers, ao.WriteUsers
+	case "jwt":
+		if jwtAuth == nil {
+			return nil, fmt.Errorf("'jwt-jwks-file' is required when 'views-auth' is 'jwt'")
+		}
+		a.jwt = jwtAuth
+		a.read, a.write = ao.ReadRoles, ao.WriteRoles
+	default:
+		return nil, fmt.Errorf("Unknown views auth type '%s'. Use 'none', 'token', 'basic' or 'jwt'", ao.Type)
+	}
+	if a.typ != "none" && len(a.write) == 0 {
+		logrus.Warnf("No write credentials configured for the /views API. Views can't be changed")
+	}
+	return a, nil
+}
+
+//requireViewsRole only lets requests with the given role through
+func requireViewsRole(role string) func(*gin.Context) {
+	return func(c *gin.Context) {
+		if viewsAuth.typ == "none" {
+			return
+		}
+		roles, err := viewsAuth.roles(c.Request)
+		if err != nil {
+			logrus.Debugf("Views API authentication failed. err=%s", err)
+			viewsAuth.challenge(c)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+			return
+		}
+		if !roles[role] {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": fmt.Sprintf("The '%s' role is required", role)})
+			return
+		}
+	}
+}
+
+//roles returns the roles of the caller or an error if credentials are missing or invalid
+func (a *viewsAuthenticator) roles(r *http.Request) (map[string]bool, error) {
+	var credential string
+	switch a.typ {
+	case "basic":
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, fmt.Errorf("Credentials required")
+		}
+		credential = username + ":" + password
+	default:
+		token := bearerToken(r)
+		if token == "" {
+			return nil, fmt.Errorf("Credentials required")
+		}
+		credential = token
+	}
+
+	if a.typ == "jwt" {
+		claims, err := a.jwt.validate(credential)
+		if err != nil {
+			return nil, err
+		}
+		roles := make(map[string]bool)
+		for _, cr := range a.jwt.roles(claims) {
+			if containsString(a.write, cr) {
+				roles[roleWrite] = true
+				roles[roleRead] = true
+			} else if containsString(a.read, cr) {
+				roles[roleRead] = true
+			}
+		}
+		return roles, nil
+	}
+
+	if matchesSecret(a.write, credential) {
+		return map[string]bool{roleRead: true, roleWrite: true}, nil
+	}
+	if matchesSecret(a.read, credential) {
+		return map[string]bool{roleRead: true}, nil
+	}
+	return nil, fmt.Errorf("Invalid credentials")
+}
+
+func (a *viewsAuthenticator) challenge(c *gin.Context) {
+	if a.typ == "basic" {
+		c.Header("WWW-Authenticate", `Basic realm="wfs-eye"`)
+		return
+	}
+	c.Header("WWW-Authenticate", `Bearer realm="wfs-eye"`)
+}
+
+//bearerToken returns the token of an 'Authorization: Bearer' header or ""
+func bearerToken(r *http.Request) string {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(h[7:])
+}
+
+//matchesSecret compares in constant time so that secrets can't be guessed by timing
+func matchesSecret(secrets []string, value string) bool {
+	found := false
+	for _, s := range secrets {
+		if subtle.ConstantTimeCompare([]byte(s), []byte(value)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
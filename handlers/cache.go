@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+//lruCache is a goroutine safe cache limited by the total cost of its entries (1 per entry unless
+//set with SetWithCost), evicting the least recently used entries when full. Each entry expires after its own TTL
+type lruCache struct {
+	mutex   sync.Mutex
+	maxSize int
+	size    int
+	ttl     time.Duration
+	ll      *list.List
+	items   map[string]*list.Element
+	hits    uint64
+	misses  uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	cost    int
+	expires time.Time
+}
+
+//newLRUCache creates a cache holding up to maxSize entries (or cost). maxSize <= 0 disables the cache
+//and ttl <= 0 means entries only leave the cache when evicted
+func newLRUCache(maxSize int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.removeElement(el)
+		c.misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.hits++
+	return e.value, true
+}
+
+//Set stores value using the cache default TTL
+func (c *lruCache) Set(key string, value interface{}) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+func (c *lruCache) SetWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.SetWithCost(key, value, 1, ttl)
+}
+
+//SetWithCost stores value counting cost against maxSize. Values costing more than maxSize are not stored
+func (c *lruCache) SetWithCost(key string, value interface{}, cost int, ttl time.Duration) {
+	if c.maxSize <= 0 || cost > c.maxSize {
+		return
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		c.size += cost - e.cost
+		e.value = value
+		e.cost = cost
+		e.expires = expires
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, cost: cost, expires: expires})
+		c.size += cost
+	}
+	for c.size > c.maxSize {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lruCache) Delete(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.removeElement(el)
+	}
+}
+
+//DeleteFunc removes the entries whose value match
+func (c *lruCache) DeleteFunc(match func(value interface{}) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, el := range c.items {
+		if match(el.Value.(*cacheEntry).value) {
+			c.removeElement(el)
+		}
+	}
+}
+
+//Purge removes all entries
+func (c *lruCache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.size = 0
+}
+
+//Stats returns hit and miss counters since the cache was created and its current size (total cost)
+func (c *lruCache) Stats() (hits uint64, misses uint64, size int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hits, c.misses, c.size
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	e := el.Value.(*cacheEntry)
+	delete(c.items, e.key)
+	c.size -= e.cost
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const crs84 = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+
+func listCollections(opt Options) func(*gin.Context) {
+	return func(c *gin.Context) {
+		base := baseURL(c)
+
+		cons, err := requestConsumer(c.Request)
+		if err != nil {
+			sendUnauthorized(c, err.Error())
+			return
+		}
+
+		views, err := opt.ViewStore.List()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error listing views. err=%s", err)})
+			logrus.Warnf("Error listing views. err=%s", err)
+			return
+		}
+		viewsByName := make(map[string]View)
+		names := make([]string, 0)
+		for _, v := range views {
+			if v.Name == nil {
+				continue
+			}
+			viewsByName[*v.Name] = v
+			names = append(names, *v.Name)
+		}
+		sort.Strings(names)
+
+		collections := make([]interface{}, 0)
+		for _, name := range names {
+			//views the caller can't read are not listed
+			if !cons.canReadChain(viewChainIn(viewsByName, name)) {
+				continue
+			}
+			collections = append(collections, viewCollection(base, viewsByName[name]))
+		}
+
+		if opt.CollectionsUpstream {
+			//views are still listed if upstreams are down
+			for _, uc := range fetchAllUpstreamCollections(c.Request.Context()) {
+				id := uc["id"].(string)
+				//a view with the same name hides the upstream collection
+				if containsString(names, id) || !published(id) {
+					continue
+				}
+				uc["links"] = collectionLinks(base, id)
+				setCollectionCRS(uc, supportedCRS)
+				collections = append(collections, uc)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"links": []Link{
+				{Href: base + "/collections", Rel: "self", Type: "application/json", Title: "This document"},
+			},
+			"collections": collections,
+		})
+	}
+}
+
+func getCollection(opt Options) func(*gin.Context) {
+	return func(c *gin.Context) {
+		name := c.Param("collection")
+		base := baseURL(c)
+		if !authorizeCollection(c, name) {
+			return
+		}
+
+		chain, upstreamName, err := resolveViewChain(c.Request.Context(), name)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error resolving collection. err=%s", err)})
+			logrus.Warnf("Error resolving collection %s. err=%s", name, err)
+			return
+		}
+
+		upstream, err := fetchUpstreamCollection(c.Request.Context(), upstreamName)
+		if err != nil {
+			if len(chain) == 0 {
+				if err == errCollectionNotFound {
+					c.JSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("Collection %s not found", name)})
+					return
+				}
+				c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error getting collection. err=%s", err)})
+				logrus.Warnf("Error getting collection %s. err=%s", name, err)
+				return
+			}
+			//the view restrictions are still known without upstream metadata
+			logrus.Warnf("Couldn't get upstream collection %s for view %s. err=%s", upstreamName, name, err)
+		}
+
+		if len(chain) == 0 {
+			upstream["id"] = name
+			upstream["links"] = collectionLinks(base, name)
+			setCollectionCRS(upstream, supportedCRS)
+			c.JSON(http.StatusOK, upstream)
+			return
+		}
+
+		c.JSON(http.StatusOK, viewChainCollection(base, name, chain, upstreamName, upstream))
+	}
+}
+
+//viewChainCollection describes a view using the restrictions of its whole chain.
+//Metadata not defined by views is taken from the upstream collection
+func viewChainCollection(base string, name string, chain []View, upstreamName string, upstream map[string]interface{}) gin.H {
+	col := gin.H{}
+	for k, v := range upstream {
+		col[k] = v
+	}
+	col["id"] = name
+	col["title"] = name
+	col["description"] = fmt.Sprintf("View over collection %s", upstreamName)
+	desc, ok := upstream["description"].(string)
+	if ok && desc != "" {
+		col["description"] = fmt.Sprintf("View over collection %s: %s", upstreamName, desc)
+	}
+	if _, ok := col["itemType"]; !ok {
+		col["itemType"] = "feature"
+	}
+	//wfs-eye converts features from CRS84, whatever CRSs upstream supports
+	setCollectionCRS(col, viewCRS(chain[0]))
+
+	extent := gin.H{}
+	ue, ok := upstream["extent"].(map[string]interface{})
+	if ok {
+		for k, v := range ue {
+			extent[k] = v
+		}
+	}
+	bbox, start, end := chainRestrictions(chain)
+	for k, v := range collectionExtent(bbox, start, end) {
+		extent[k] = v
+	}
+	delete(col, "extent")
+	if len(extent) > 0 {
+		col["extent"] = extent
+	}
+
+	col["links"] = collectionLinks(base, name)
+	return col
+}
+
+//chainRestrictions returns the intersection of 'maxBbox', the envelope of 'maxGeometry' and 'maxTimeRange'
+//of all views in chain. bbox is nil and start/end are nil if no view restricts them
+func chainRestrictions(chain []View) (bbox []float64, start *time.Time, end *time.Time) {
+	for _, v := range chain {
+		bb := viewExtent(v)
+		if bb != nil {
+			if bbox == nil {
+				bbox = bb
+			} else {
+				bbox = intersectionBBox(bbox, bb)
+			}
+		}
+		if v.MaxTimeRange != nil {
+			s, e, err := getDateStartEndFromString(*v.MaxTimeRange)
+			if err != nil {
+				continue
+			}
+			if s != nil && (start == nil || s.After(*start)) {
+				start = s
+			}
+			if e != nil && (end == nil || e.Before(*end)) {
+				end = e
+			}
+		}
+	}
+	return bbox, start, end
+}
+
+//viewExtent is the intersection of 'maxBbox' and the envelope of 'maxGeometry' as (minx,miny,maxx,maxy).
+//It is nil if the view doesn't restrict the area
+func viewExtent(view View) []float64 {
+	var bbox []float64
+	if view.MaxBBox != nil && len(*view.MaxBBox) == 4 {
+		bbox = bboxExtent(*view.MaxBBox)
+	}
+	if view.MaxGeometry != nil {
+		env := bboxExtent(view.MaxGeometry.envelope())
+		if bbox == nil {
+			bbox = env
+		} else {
+			bbox = intersectionBBox(bbox, env)
+		}
+	}
+	return bbox
+}
+
+//intersectionBBox intersects two (minx,miny,maxx,maxy) bboxes. If they don't
+//overlap the result is collapsed to a zero area bbox
+func intersectionBBox(a []float64, b []float64) []float64 {
+	r := []float64{
+		math.Max(a[0], b[0]),
+		math.Max(a[1], b[1]),
+		math.Min(a[2], b[2]),
+		math.Min(a[3], b[3]),
+	}
+	if r[2] < r[0] {
+		r[2] = r[0]
+	}
+	if r[3] < r[1] {
+		r[3] = r[1]
+	}
+	return r
+}
+
+//viewCollection describes a view as an OGC API Features collection
+func viewCollection(base string, view View) gin.H {
+	name := *view.Name
+	col := gin.H{
+		"id":          name,
+		"title":       name,
+		"description": fmt.Sprintf("View over collection %s", view.Collection),
+		"itemType":    "feature",
+		"links":       collectionLinks(base, name),
+	}
+	setCollectionCRS(col, viewCRS(view))
+	bbox := viewExtent(view)
+	var start, end *time.Time
+	if view.MaxTimeRange != nil {
+		start, end, _ = getDateStartEndFromString(*view.MaxTimeRange)
+	}
+	extent := collectionExtent(bbox, start, end)
+	if extent != nil {
+		col["extent"] = extent
+	}
+	return col
+}
+
+//setCollectionCRS sets the CRSs features of a collection can be requested in. Features are stored in CRS84 upstream
+func setCollectionCRS(col map[string]interface{}, defs []*crsDef) {
+	col["crs"] = crsURIs(defs)
+	col["storageCrs"] = crs84
+}
+
+//collectionExtent returns nil if neither bbox nor time interval is known
+func collectionExtent(bbox []float64, start *time.Time, end *time.Time) gin.H {
+	extent := gin.H{}
+	if len(bbox) == 4 {
+		extent["spatial"] = gin.H{
+			"bbox": [][]float64{bboxExtent(bbox)},
+			"crs":  crs84,
+		}
+	}
+	if start != nil || end != nil {
+		interval := make([]interface{}, 2)
+		if start != nil {
+			interval[0] = start.Format(time.RFC3339)
+		}
+		if end != nil {
+			interval[1] = end.Format(time.RFC3339)
+		}
+		extent["temporal"] = gin.H{
+			"interval": [][]interface{}{interval},
+			"trs":      "http://www.opengis.net/def/uom/ISO-8601/0/Gregorian",
+		}
+	}
+	if len(extent) == 0 {
+		return nil
+	}
+	return extent
+}
+
+//bboxExtent normalizes a bbox in any corner order to (minx,miny,maxx,maxy) as required by extents
+func bboxExtent(bb []float64) []float64 {
+	return []float64{
+		math.Min(bb[0], bb[2]),
+		math.Min(bb[1], bb[3]),
+		math.Max(bb[0], bb[2]),
+		math.Max(bb[1], bb[3]),
+	}
+}
+
+func collectionLinks(base string, name string) []Link {
+	return []Link{
+		{Href: fmt.Sprintf("%s/collections/%s", base, name), Rel: "self", Type: "application/json", Title: "This collection"},
+		{Href: fmt.Sprintf("%s/collections/%s/items", base, name), Rel: "items", Type: "application/geo+json", Title: "Features"},
+	}
+}
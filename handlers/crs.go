@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/project"
+)
+
+const epsgURI = "http://www.opengis.net/def/crs/EPSG/0/"
+
+//supportedCRS are the CRSs clients can use in 'crs' and 'bbox-crs'. CRS84 is always the first
+var supportedCRS []*crsDef
+
+//crsDef is a coordinate reference system wfs-eye can convert features to. Upstream features are always CRS84
+type crsDef struct {
+	uri string
+	//latLon is true for CRSs with latitude as the first axis, like EPSG:4326
+	latLon bool
+	//forward converts CRS84 coordinates to projected coordinates. nil for geographic CRSs
+	forward func(orb.Point) orb.Point
+	//inverse converts projected coordinates to CRS84
+	inverse func(orb.Point) orb.Point
+}
+
+var crs84Def = &crsDef{uri: crs84}
+
+//parseCRS accepts CRS URIs, as in 'http://www.opengis.net/def/crs/EPSG/0/3857', and the 'EPSG:3857' and 'CRS84' forms.
+//Supported CRSs are CRS84, EPSG:4326, EPSG:3857 and the WGS 84 UTM zones (EPSG:32601 to 32660 and 32701 to 32760)
+func parseCRS(s string) (*crsDef, error) {
+	id := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(s), "["), "]")
+	switch id {
+	case crs84, "CRS84", "OGC:CRS84":
+		return crs84Def, nil
+	}
+	code := ""
+	if strings.HasPrefix(id, epsgURI) {
+		code = strings.TrimPrefix(id, epsgURI)
+	} else if strings.HasPrefix(strings.ToUpper(id), "EPSG:") {
+		code = id[len("EPSG:"):]
+	}
+	epsg, err := strconv.Atoi(code)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown CRS '%s'", s)
+	}
+
+	def := &crsDef{uri: fmt.Sprintf("%s%d", epsgURI, epsg)}
+	switch {
+	case epsg == 4326:
+		def.latLon = true
+	case epsg == 3857:
+		def.forward = webMercatorForward
+		def.inverse = webMercatorInverse
+	case epsg >= 32601 && epsg <= 32660:
+		def.forward, def.inverse = utmProjection(epsg-32600, false)
+	case epsg >= 32701 && epsg <= 32760:
+		def.forward, def.inverse = utmProjection(epsg-32700, true)
+	default:
+		return nil, fmt.Errorf("CRS '%s' is not supported", s)
+	}
+	return def, nil
+}
+
+//parseCRSList parses the CRSs clients can request. CRS84 is added if missing
+func parseCRSList(list []string) ([]*crsDef, error) {
+	defs := []*crsDef{crs84Def}
+	for _, s := range list {
+		def, err := parseCRS(s)
+		if err != nil {
+			return nil, err
+		}
+		if findCRS(defs, def.uri) == nil {
+			defs = append(defs, def)
+		}
+	}
+	return defs, nil
+}
+
+func findCRS(defs []*crsDef, uri string) *crsDef {
+	for _, d := range defs {
+		if d.uri == uri {
+			return d
+		}
+	}
+	return nil
+}
+
+//crsURIs lists the URIs of defs, as in the 'crs' member of collections
+func crsURIs(defs []*crsDef) []string {
+	uris := make([]string, 0)
+	for _, d := range defs {
+		uris = append(uris, d.uri)
+	}
+	return uris
+}
+
+//viewCRS are the CRSs allowed for a view: its 'crs' list or all supported CRSs
+func viewCRS(view View) []*crsDef {
+	if view.CRS == nil {
+		return supportedCRS
+	}
+	//the list was validated when the view was stored
+	defs, err := parseCRSList(*view.CRS)
+	if err != nil {
+		return []*crsDef{crs84Def}
+	}
+	return defs
+}
+
+//validateViewCRS checks that all CRSs of a view 'crs' list are supported by this server
+func validateViewCRS(list []string) error {
+	for _, s := range list {
+		def, err := parseCRS(s)
+		if err != nil {
+			return err
+		}
+		if findCRS(supportedCRS, def.uri) == nil {
+			return fmt.Errorf("CRS '%s' is not enabled in this server", s)
+		}
+	}
+	return nil
+}
+
+//collectionCRS returns the CRSs allowed for collection
+func collectionCRS(c *gin.Context, collection string) ([]*crsDef, error) {
+	view, err := findView(c.Request.Context(), collection)
+	if err == ErrViewNotFound {
+		return supportedCRS, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return viewCRS(view), nil
+}
+
+//requestCRS returns the CRS of the query parameter param or CRS84 if it's not set. If the CRS is not
+//allowed for collection, the response is sent and false is returned
+func requestCRS(c *gin.Context, collection string, param string) (*crsDef, bool) {
+	s := c.Query(param)
+	if s == "" {
+		return crs84Def, true
+	}
+	def, err := parseCRS(s)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid '%s'. err=%s", param, err)})
+		return nil, false
+	}
+	allowed, err := collectionCRS(c, collection)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error getting view. err=%s", err)})
+		return nil, false
+	}
+	if findCRS(allowed, def.uri) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid '%s'. Collection %s supports %s", param, collection, strings.Join(crsURIs(allowed), ", "))})
+		return nil, false
+	}
+	return def, true
+}
+
+//requestBBox returns the 'bbox' query parameter converted from 'bbox-crs' to CRS84, in (west,north,east,south) order.
+//If it is invalid, the response is sent and false is returned
+func requestBBox(c *gin.Context, collection string) (string, bool) {
+	bboxstr := c.Query("bbox")
+	if bboxstr == "" {
+		return "", true
+	}
+	bboxCRS, ok := requestCRS(c, collection, "bbox-crs")
+	if !ok {
+		return "", false
+	}
+	bb, err := bboxFromString(bboxstr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid 'bbox'. err=%s", err)})
+		return "", false
+	}
+	if bboxCRS != crs84Def {
+		bb = bboxCRS.bboxToCRS84(bb)
+		bboxstr = fmt.Sprintf("%f,%f,%f,%f", bb[0], bb[1], bb[2], bb[3])
+	}
+	if !validBBox(bb) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'bbox'. It must be in order (west,north,east,south)"})
+		return "", false
+	}
+	return bboxstr, true
+}
+
+//toCRS84 converts a position in this CRS, in its axis order, to CRS84
+func (d *crsDef) toCRS84(p orb.Point) orb.Point {
+	if d.latLon {
+		p = orb.Point{p[1], p[0]}
+	}
+	if d.inverse != nil {
+		p = d.inverse(p)
+	}
+	return p
+}
+
+//fromCRS84 converts a CRS84 position to this CRS, in its axis order
+func (d *crsDef) fromCRS84(p orb.Point) orb.Point {
+	if d.forward != nil {
+		p = d.forward(p)
+	}
+	if d.latLon {
+		p = orb.Point{p[1], p[0]}
+	}
+	return p
+}
+
+//bboxToCRS84 converts a bbox whose corners are in this CRS to the CRS84 bbox that contains it.
+//Edges are densified because straight lines in projected CRSs are curves in CRS84
+func (d *crsDef) bboxToCRS84(bb []float64) []float64 {
+	const steps = 10
+	c1 := orb.Point{bb[0], bb[1]}
+	c2 := orb.Point{bb[2], bb[3]}
+	bound := orb.Bound{Min: d.toCRS84(c1), Max: d.toCRS84(c1)}
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / steps
+		x := c1[0] + (c2[0]-c1[0])*t
+		y := c1[1] + (c2[1]-c1[1])*t
+		for _, p := range []orb.Point{{x, c1[1]}, {x, c2[1]}, {c1[0], y}, {c2[0], y}} {
+			bound = bound.Extend(d.toCRS84(p))
+		}
+	}
+	return []float64{bound.Min[0], bound.Max[1], bound.Max[0], bound.Min[1]}
+}
+
+//transformFeature converts the geometry of a CRS84 feature to this CRS
+func (d *crsDef) transformFeature(f *geojson.Feature) {
+	if d == nil || d == crs84Def || f.Geometry == nil {
+		return
+	}
+	f.Geometry = project.Geometry(f.Geometry, d.fromCRS84)
+	if f.BBox != nil {
+		f.BBox = geojson.NewBBox(f.Geometry.Bound())
+	}
+}
+
+//contentCRS is the value of the Content-Crs header
+func (d *crsDef) contentCRS() string {
+	return "<" + d.uri + ">"
+}
+
+const (
+	earthRadius = 6378137.0
+	//webMercatorMaxLat is the latitude where EPSG:3857 becomes a square
+	webMercatorMaxLat = 85.0511287798
+)
+
+func webMercatorForward(p orb.Point) orb.Point {
+	lat := math.Max(math.Min(p[1], webMercatorMaxLat), -webMercatorMaxLat)
+	return orb.Point{
+		earthRadius * p[0] * math.Pi / 180,
+		earthRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)),
+	}
+}
+
+func webMercatorInverse(p orb.Point) orb.Point {
+	return orb.Point{
+		p[0] / earthRadius * 180 / math.Pi,
+		(2*math.Atan(math.Exp(p[1]/earthRadius)) - math.Pi/2) * 180 / math.Pi,
+	}
+}
+
+//utmProjection returns the transverse mercator formulas (Snyder, USGS Professional Paper 1395) of a WGS 84 UTM zone
+func utmProjection(zone int, south bool) (forward func(orb.Point) orb.Point, inverse func(orb.Point) orb.Point) {
+	const (
+		a  = 6378137.0
+		f  = 1 / 298.257223563
+		k0 = 0.9996
+		x0 = 500000.0
+	)
+	e2 := f * (2 - f)
+	ep2 := e2 / (1 - e2)
+	e4 := e2 * e2
+	e6 := e4 * e2
+	y0 := 0.0
+	if south {
+		y0 = 10000000
+	}
+	lon0 := float64((zone-1)*6-180+3) * math.Pi / 180
+
+	meridianArc := func(phi float64) float64 {
+		return a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+			(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+			(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+			(35*e6/3072)*math.Sin(6*phi))
+	}
+
+	forward = func(p orb.Point) orb.Point {
+		phi := p[1] * math.Pi / 180
+		lam := p[0] * math.Pi / 180
+		sin, cos, tan := math.Sin(phi), math.Cos(phi), math.Tan(phi)
+		n := a / math.Sqrt(1-e2*sin*sin)
+		t := tan * tan
+		c := ep2 * cos * cos
+		aa := cos * (lam - lon0)
+		m := meridianArc(phi)
+		x := k0*n*(aa+(1-t+c)*math.Pow(aa, 3)/6+(5-18*t+t*t+72*c-58*ep2)*math.Pow(aa, 5)/120) + x0
+		y := k0*(m+n*tan*(aa*aa/2+(5-t+9*c+4*c*c)*math.Pow(aa, 4)/24+(61-58*t+t*t+600*c-330*ep2)*math.Pow(aa, 6)/720)) + y0
+		return orb.Point{x, y}
+	}
+
+	inverse = func(p orb.Point) orb.Point {
+		x := p[0] - x0
+		y := p[1] - y0
+		m := y / k0
+		mu := m / (a * (1 - e2/4 - 3*e4/64 - 5*e6/256))
+		e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+		phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+			(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+		sin, cos, tan := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+		c1 := ep2 * cos * cos
+		t1 := tan * tan
+		n1 := a / math.Sqrt(1-e2*sin*sin)
+		r1 := a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+		d := x / (n1 * k0)
+		phi := phi1 - (n1*tan/r1)*(d*d/2-(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		lam := lon0 + (d-(1+2*t1+c1)*math.Pow(d, 3)/6+(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120)/cos
+		return orb.Point{lam * 180 / math.Pi, phi * 180 / math.Pi}
+	}
+	return forward, inverse
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+//queryExplanation records how resolveFeatureCollection rewrote a features query
+type queryExplanation struct {
+	Collection  string        `json:"collection"`
+	Steps       []explainStep `json:"steps"`
+	UpstreamURL string        `json:"upstreamUrl"`
+}
+
+//explainStep is a collection of the view chain with the parameters it received and passed on
+type explainStep struct {
+	Collection string        `json:"collection"`
+	View       bool          `json:"view"`
+	Input      explainParams `json:"input"`
+	//Defaults are the view defaults used because the parameter was not set
+	Defaults []string `json:"defaultsApplied"`
+	//Restrictions are the view restrictions that changed a parameter
+	Restrictions []string      `json:"restrictionsApplied"`
+	Output       explainParams `json:"output"`
+}
+
+type explainParams struct {
+	BBox   string `json:"bbox"`
+	Limit  string `json:"limit"`
+	Time   string `json:"time"`
+	Filter string `json:"filter"`
+}
+
+func newExplainStep(collectionName string, view bool, bboxstr string, limitstr string, timestr string, propertiesFilterStr string) *explainStep {
+	return &explainStep{
+		Collection:   collectionName,
+		View:         view,
+		Input:        explainParams{BBox: bboxstr, Limit: limitstr, Time: timestr, Filter: trimFilter(propertiesFilterStr)},
+		Defaults:     make([]string, 0),
+		Restrictions: make([]string, 0),
+	}
+}
+
+func (s *explainStep) defaultApplied(format string, args ...interface{}) {
+	if s != nil {
+		s.Defaults = append(s.Defaults, fmt.Sprintf(format, args...))
+	}
+}
+
+func (s *explainStep) restrictionApplied(format string, args ...interface{}) {
+	if s != nil {
+		s.Restrictions = append(s.Restrictions, fmt.Sprintf(format, args...))
+	}
+}
+
+//trimFilter removes the separators left by the concatenation of filters
+func trimFilter(propertiesFilterStr string) string {
+	v, err := url.ParseQuery(propertiesFilterStr)
+	if err != nil {
+		return propertiesFilterStr
+	}
+	return v.Encode()
+}
+
+//sameBBox tells whether two bbox strings have the same coordinates, even if formatted differently
+func sameBBox(bboxstr1 string, bboxstr2 string) bool {
+	bb1, err1 := bboxFromString(bboxstr1)
+	bb2, err2 := bboxFromString(bboxstr2)
+	if err1 != nil || err2 != nil || len(bb1) != len(bb2) {
+		return bboxstr1 == bboxstr2
+	}
+	for i := range bb1 {
+		if math.Abs(bb1[i]-bb2[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+//explainQuery shows how a features query is rewritten by each view of the chain without calling upstream
+func explainQuery() func(*gin.Context) {
+	return func(c *gin.Context) {
+		collection := c.Param("collection")
+		if !authorizeCollection(c, collection) {
+			return
+		}
+
+		bboxstr, ok := requestBBox(c, collection)
+		if !ok {
+			return
+		}
+		pagingstr, propertiesFilterStr := splitQueryParams(c.Request.URL.Query())
+
+		ex := &queryExplanation{Collection: collection, Steps: make([]explainStep, 0)}
+		_, err := resolveFeatureCollection(c.Request.Context(), collection, bboxstr, c.Query("limit"), c.Query("time"), pagingstr, propertiesFilterStr, make([]string, 0), ex)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error resolving query. err=%s", err)})
+			logrus.Warnf("Error explaining query. err=%s", err)
+			return
+		}
+		//upstreamUrl is written without escaping '&'
+		data, err := marshalJSON(ex)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error writing explanation. err=%s", err)})
+			logrus.Warnf("Error writing explanation. err=%s", err)
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulsmith/gogeos/geos"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//ViewGeometry is a GeoJSON Polygon or MultiPolygon used as a view restriction.
+//It is kept as GeoJSON in the view store too
+type ViewGeometry struct {
+	geometry orb.Geometry
+
+	//the GEOS geometry is prepared once per view definition, as it is tested against every feature
+	prepareOnce sync.Once
+	geos        *geos.Geometry
+	prepared    *geos.PGeometry
+	prepareErr  error
+}
+
+func (g *ViewGeometry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(geojson.NewGeometry(g.geometry))
+}
+
+func (g *ViewGeometry) UnmarshalJSON(data []byte) error {
+	gj, err := geojson.UnmarshalGeometry(data)
+	if err != nil {
+		return err
+	}
+	g.geometry = gj.Geometry()
+	return nil
+}
+
+//GetBSON stores the geometry as a GeoJSON document
+func (g *ViewGeometry) GetBSON() (interface{}, error) {
+	data, err := g.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+func (g *ViewGeometry) SetBSON(raw bson.Raw) error {
+	var m map[string]interface{}
+	err := raw.Unmarshal(&m)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return g.UnmarshalJSON(data)
+}
+
+func (g *ViewGeometry) validate() error {
+	var polygons orb.MultiPolygon
+	switch geom := g.geometry.(type) {
+	case orb.Polygon:
+		polygons = orb.MultiPolygon{geom}
+	case orb.MultiPolygon:
+		polygons = geom
+	default:
+		return fmt.Errorf("It must be a GeoJSON Polygon or MultiPolygon")
+	}
+	if len(polygons) == 0 {
+		return fmt.Errorf("It must have at least one polygon")
+	}
+	for _, p := range polygons {
+		if len(p) == 0 {
+			return fmt.Errorf("Polygons must have an exterior ring")
+		}
+		for _, r := range p {
+			if len(r) < 4 || !r.Closed() {
+				return fmt.Errorf("Polygon rings must be closed and have at least 4 positions")
+			}
+		}
+	}
+	return nil
+}
+
+//envelope is the bounding box of the geometry in (west,north,east,south) order, as 'maxBbox'
+func (g *ViewGeometry) envelope() []float64 {
+	b := g.geometry.Bound()
+	return []float64{b.Min[0], b.Max[1], b.Max[0], b.Min[1]}
+}
+
+//prepare converts the geometry to GEOS once
+func (g *ViewGeometry) prepare() (*geos.PGeometry, error) {
+	g.prepareOnce.Do(func() {
+		g.geos, g.prepareErr = toGEOS(g.geometry)
+		if g.prepareErr == nil {
+			g.prepared = g.geos.Prepare()
+		}
+	})
+	return g.prepared, g.prepareErr
+}
+
+//intersects tells whether a feature geometry has any point inside the polygon
+func (g *ViewGeometry) intersects(other orb.Geometry) (bool, error) {
+	if other == nil {
+		return false, nil
+	}
+	//most features outside the polygon are discarded without GEOS
+	if !g.geometry.Bound().Intersects(other.Bound()) {
+		return false, nil
+	}
+	pg, err := g.prepare()
+	if err != nil {
+		return false, err
+	}
+	og, err := toGEOS(other)
+	if err != nil {
+		return false, err
+	}
+	return pg.Intersects(og)
+}
+
+//toGEOS converts an orb geometry to a GEOS geometry
+func toGEOS(g orb.Geometry) (*geos.Geometry, error) {
+	data, err := wkb.Marshal(g)
+	if err != nil {
+		return nil, err
+	}
+	return geos.FromWKB(data)
+}
+
+//clip intersects a geometry with the polygon. The result is nil if they don't overlap
+func (g *ViewGeometry) clip(other orb.Geometry) (orb.Geometry, error) {
+	if other == nil || !g.geometry.Bound().Intersects(other.Bound()) {
+		return nil, nil
+	}
+	pg, err := g.prepare()
+	if err != nil {
+		return nil, err
+	}
+	og, err := toGEOS(other)
+	if err != nil {
+		return nil, err
+	}
+	//features inside the polygon are kept as they are
+	inside, err := pg.Contains(og)
+	if err != nil {
+		return nil, err
+	}
+	if inside {
+		return other, nil
+	}
+	return clipGEOS(other, og, g.geos)
+}
+
+//clipToView intersects a feature geometry with 'maxBbox' and 'maxGeometry' of view.
+//The result is nil if nothing is left inside the view boundary
+func clipToView(view View, g orb.Geometry) (orb.Geometry, error) {
+	if g == nil {
+		return nil, nil
+	}
+	if view.MaxBBox != nil && len(*view.MaxBBox) == 4 {
+		bb := bboxExtent(*view.MaxBBox)
+		bound := orb.Bound{Min: orb.Point{bb[0], bb[1]}, Max: orb.Point{bb[2], bb[3]}}
+		gb := g.Bound()
+		if !bound.Intersects(gb) {
+			return nil, nil
+		}
+		if !bound.Contains(gb.Min) || !bound.Contains(gb.Max) {
+			og, err := toGEOS(g)
+			if err != nil {
+				return nil, err
+			}
+			rect, err := toGEOS(bound.ToPolygon())
+			if err != nil {
+				return nil, err
+			}
+			g, err = clipGEOS(g, og, rect)
+			if err != nil || g == nil {
+				return nil, err
+			}
+		}
+	}
+	if view.MaxGeometry != nil {
+		return view.MaxGeometry.clip(g)
+	}
+	return g, nil
+}
+
+//clipGEOS intersects g, converted to GEOS as og, with boundary. Only the parts with the same dimension as g
+//are kept, so that a polygon that touches the boundary doesn't become a line. nil is returned if nothing is left
+func clipGEOS(g orb.Geometry, og *geos.Geometry, boundary *geos.Geometry) (orb.Geometry, error) {
+	r, err := og.Intersection(boundary)
+	if err != nil {
+		return nil, err
+	}
+	empty, err := r.IsEmpty()
+	if err != nil || empty {
+		return nil, err
+	}
+	clipped, err := fromGEOS(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := g.(orb.Collection); ok {
+		return clipped, nil
+	}
+	return withDimension(clipped, g.Dimensions()), nil
+}
+
+//withDimension returns the parts of g with dimension d as a single geometry or a multi geometry if there are
+//many parts. GEOS may return a GeometryCollection or lower dimension parts where geometries touch the boundary
+func withDimension(g orb.Geometry, d int) orb.Geometry {
+	var points orb.MultiPoint
+	var lines orb.MultiLineString
+	var polygons orb.MultiPolygon
+	var collect func(g orb.Geometry)
+	collect = func(g orb.Geometry) {
+		switch gg := g.(type) {
+		case orb.Point:
+			points = append(points, gg)
+		case orb.MultiPoint:
+			points = append(points, gg...)
+		case orb.LineString:
+			lines = append(lines, gg)
+		case orb.MultiLineString:
+			lines = append(lines, gg...)
+		case orb.Polygon:
+			polygons = append(polygons, gg)
+		case orb.MultiPolygon:
+			polygons = append(polygons, gg...)
+		case orb.Collection:
+			for _, p := range gg {
+				collect(p)
+			}
+		}
+	}
+	collect(g)
+
+	switch {
+	case d == 0 && len(points) == 1:
+		return points[0]
+	case d == 0 && len(points) > 1:
+		return points
+	case d == 1 && len(lines) == 1:
+		return lines[0]
+	case d == 1 && len(lines) > 1:
+		return lines
+	case d == 2 && len(polygons) == 1:
+		return polygons[0]
+	case d == 2 && len(polygons) > 1:
+		return polygons
+	}
+	return nil
+}
+
+//fromGEOS converts a GEOS geometry to an orb geometry
+func fromGEOS(g *geos.Geometry) (orb.Geometry, error) {
+	data, err := g.WKB()
+	if err != nil {
+		return nil, err
+	}
+	return wkb.Unmarshal(data)
+}
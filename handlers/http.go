@@ -3,12 +3,10 @@ package handlers
 import (
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	mgo "gopkg.in/mgo.v2"
 
 	cors "github.com/itsjamie/gin-cors"
 )
@@ -24,7 +22,34 @@ type Options struct {
 	MongoAddress  string
 	MongoUsername string
 	MongoPassword string
-	MongoSession  *mgo.Session
+	Upstream      UpstreamOptions
+	UpstreamsFile string
+	ViewStoreType string
+	ViewStoreFile string
+	ViewStore     ViewStore
+
+	ViewCacheSize         int
+	ViewCacheTTL          time.Duration
+	ViewNotFoundCacheSize int
+	ViewNotFoundCacheTTL  time.Duration
+	ViewWatchInterval     time.Duration
+
+	CollectionsUpstream bool
+	//ResponseCacheSize is the max bytes of upstream responses kept in memory. 0 disables the cache
+	ResponseCacheSize int
+	//ResponseCacheTTL is the time upstream responses are cached for views without 'cacheTTL' and collections
+	ResponseCacheTTL time.Duration
+	//PublishedCollections are patterns ('*', 'agency1:*') of upstream collections that can be queried without a view
+	PublishedCollections []string
+	//CRS are the CRSs clients can request features in besides CRS84, as 'EPSG:3857' or CRS URIs
+	CRS []string
+
+	RateLimit RateLimitOptions
+
+	ViewsAuth ViewsAuthOptions
+	JWT       JWTOptions
+
+	Tracing TracingOptions
 }
 
 func NewHTTPServer(opt Options) *HTTPServer {
@@ -33,7 +58,7 @@ func NewHTTPServer(opt Options) *HTTPServer {
 	router.Use(cors.Middleware(cors.Config{
 		Origins:         "*",
 		Methods:         "GET",
-		RequestHeaders:  "Origin, Content-Type",
+		RequestHeaders:  "Origin, Content-Type, Authorization, X-API-Key",
 		ExposedHeaders:  "",
 		MaxAge:          24 * 3600 * time.Second,
 		Credentials:     false,
@@ -45,39 +70,63 @@ func NewHTTPServer(opt Options) *HTTPServer {
 		Handler: router,
 	}, router: router}
 
-	logrus.Debugf("Connecting to MongoDB")
-	mongoDBDialInfo := &mgo.DialInfo{
-		Addrs:    strings.Split(opt.MongoAddress, ","),
-		Timeout:  2 * time.Second,
-		Database: opt.MongoDBName,
-		Username: opt.MongoUsername,
-		Password: opt.MongoPassword,
+	err := setupTracing(opt.Tracing)
+	if err != nil {
+		logrus.Errorf("Couldn't initialize tracing. err=%s", err)
+		os.Exit(1)
+	}
+
+	if opt.ViewStore == nil {
+		vs, err := newViewStore(opt)
+		if err != nil {
+			logrus.Errorf("Couldn't initialize view store. err=%s", err)
+			os.Exit(1)
+		}
+		opt.ViewStore = vs
+	}
+
+	supportedCRS, err = parseCRSList(opt.CRS)
+	if err != nil {
+		logrus.Errorf("Invalid CRS list. err=%s", err)
+		os.Exit(1)
+	}
+
+	err = setupUpstreams(opt)
+	if err != nil {
+		logrus.Errorf("Couldn't initialize upstreams. err=%s", err)
+		os.Exit(1)
 	}
 
-	var mongoSession *mgo.Session
-	for i := 0; i < 30; i++ {
-		ms, err := mgo.DialWithInfo(mongoDBDialInfo)
+	if opt.JWT.JWKSFile != "" {
+		jwtAuth, err = newJWTValidator(opt.JWT)
 		if err != nil {
-			logrus.Infof("Couldn't connect to mongdb. err=%s", err)
-			time.Sleep(1 * time.Second)
-			logrus.Infof("Retrying...")
-			continue
+			logrus.Errorf("Couldn't initialize JWT validation. err=%s", err)
+			os.Exit(1)
 		}
-		mongoSession = ms
-		logrus.Infof("Connected to MongoDB successfully")
-		break
 	}
 
-	if mongoSession == nil {
-		logrus.Errorf("Couldn't connect to MongoDB")
+	viewsAuth, err = newViewsAuthenticator(opt)
+	if err != nil {
+		logrus.Errorf("Couldn't initialize views API auth. err=%s", err)
 		os.Exit(1)
 	}
 
-	opt.MongoSession = mongoSession
+	rateLimits, err = newRateLimitStore(opt)
+	if err != nil {
+		logrus.Errorf("Couldn't initialize rate limits. err=%s", err)
+		os.Exit(1)
+	}
+	trustedProxies, err = parseTrustedProxies(opt.RateLimit.TrustedProxies)
+	if err != nil {
+		logrus.Errorf("Invalid trusted proxies. err=%s", err)
+		os.Exit(1)
+	}
 
 	logrus.Infof("Initializing HTTP Handlers...")
+	h.setupAPIHandlers(opt)
 	h.setupWFSHandlers(opt)
 	h.setupViewHandlers(opt)
+	h.setupMetricsHandlers(opt)
 
 	return h
 }
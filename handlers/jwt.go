@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+//jwtAuth validates JWTs sent by callers. nil if no JWKS file is configured
+var jwtAuth *jwtValidator
+
+//JWTOptions configures how JWT bearer tokens are validated
+type JWTOptions struct {
+	//JWKSFile is a local JSON Web Key Set file with the keys trusted for signing tokens
+	JWKSFile string
+	//Issuer and Audience are checked against 'iss' and 'aud' if set
+	Issuer   string
+	Audience string
+	//RolesClaim is the claim with the roles of the caller. Nested claims are separated by '.', as in 'realm_access.roles'
+	RolesClaim string
+	//GroupsClaim is the claim with the groups of the caller, checked against view access policies
+	GroupsClaim string
+}
+
+//jwtValidator checks the signature, expiration, issuer and audience of JWTs
+type jwtValidator struct {
+	keys        *jose.JSONWebKeySet
+	issuer      string
+	audience    string
+	rolesClaim  string
+	groupsClaim string
+}
+
+func newJWTValidator(jopt JWTOptions) (*jwtValidator, error) {
+	data, err := ioutil.ReadFile(jopt.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading JWKS file %s. err=%s", jopt.JWKSFile, err)
+	}
+	keys := &jose.JSONWebKeySet{}
+	err = json.Unmarshal(data, keys)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing JWKS file %s. err=%s", jopt.JWKSFile, err)
+	}
+	if len(keys.Keys) == 0 {
+		return nil, fmt.Errorf("No keys found in JWKS file %s", jopt.JWKSFile)
+	}
+	return &jwtValidator{
+		keys:        keys,
+		issuer:      jopt.Issuer,
+		audience:    jopt.Audience,
+		rolesClaim:  jopt.RolesClaim,
+		groupsClaim: jopt.GroupsClaim,
+	}, nil
+}
+
+//validate returns the claims of a valid token
+func (v *jwtValidator) validate(token string) (map[string]interface{}, error) {
+	tok, err := jwt.ParseSigned(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWT. err=%s", err)
+	}
+
+	//tokens without 'kid' are checked against all keys
+	keys := v.keys.Keys
+	for _, h := range tok.Headers {
+		if h.KeyID != "" {
+			keys = v.keys.Key(h.KeyID)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("Invalid JWT. Unknown signing key")
+	}
+
+	var std jwt.Claims
+	claims := make(map[string]interface{})
+	for _, k := range keys {
+		err = tok.Claims(k.Key, &std, &claims)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWT signature. err=%s", err)
+	}
+
+	expected := jwt.Expected{Issuer: v.issuer, Time: time.Now()}
+	if v.audience != "" {
+		expected.Audience = jwt.Audience{v.audience}
+	}
+	err = std.Validate(expected)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JWT claims. err=%s", err)
+	}
+	return claims, nil
+}
+
+//roles returns the values of the roles claim
+func (v *jwtValidator) roles(claims map[string]interface{}) []string {
+	return claimStrings(claims, v.rolesClaim)
+}
+
+//groups returns the values of the groups claim
+func (v *jwtValidator) groups(claims map[string]interface{}) []string {
+	return claimStrings(claims, v.groupsClaim)
+}
+
+//claimStrings returns a string or array of strings claim as a list. Nested claims are referenced as 'a.b'
+func claimStrings(claims map[string]interface{}, path string) []string {
+	var value interface{} = claims
+	for _, p := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[p]
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0)
+		for _, e := range v {
+			s, ok := e.(string)
+			if ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wfseye_requests_total",
+		Help: "Requests to the features API by endpoint, view ('' for upstream collections) and status code",
+	}, []string{"endpoint", "view", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wfseye_request_duration_seconds",
+		Help:    "Time to answer requests to the features API, including streaming the response",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"endpoint", "view", "status"})
+
+	responseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wfseye_response_bytes_total",
+		Help: "Bytes returned by the features API",
+	}, []string{"endpoint", "view"})
+
+	featuresReturned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wfseye_features_returned_total",
+		Help: "Features returned to clients",
+	}, []string{"view"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wfseye_upstream_request_duration_seconds",
+		Help:    "Time until the response headers of upstream WFS requests, including retries",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"upstream", "status"})
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wfseye_upstream_errors_total",
+		Help: "Failed upstream WFS requests by reason: 'network', 'status' (5xx) or 'circuit_open'",
+	}, []string{"upstream", "reason"})
+
+	responseCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "wfseye_response_cache_requests_total",
+		Help: "Lookups of cacheable upstream responses by result ('hit' or 'miss')",
+	}, []string{"result"})
+
+	mongoDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "wfseye_mongo_operation_duration_seconds",
+		Help:    "Time of MongoDB operations",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 5},
+	}, []string{"operation"})
+)
+
+func (h *HTTPServer) setupMetricsHandlers(opt Options) {
+	prometheus.MustRegister(requestsTotal, requestDuration, responseBytes, featuresReturned,
+		upstreamDuration, upstreamErrors, responseCacheRequests, mongoDuration)
+
+	//cache counters are kept by the caches themselves
+	for _, cache := range []struct {
+		name  string
+		cache func() *lruCache
+	}{
+		{"views", func() *lruCache { return viewCache }},
+		{"views_not_found", func() *lruCache { return viewNotFoundCache }},
+	} {
+		cache := cache
+		labels := prometheus.Labels{"cache": cache.name}
+		prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "wfseye_view_cache_hits_total",
+			Help:        "View definitions found in cache. 'views_not_found' caches names known not to be views",
+			ConstLabels: labels,
+		}, func() float64 {
+			hits, _, _ := cache.cache().Stats()
+			return float64(hits)
+		}))
+		prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "wfseye_view_cache_misses_total",
+			Help:        "View definitions not found in cache",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, misses, _ := cache.cache().Stats()
+			return float64(misses)
+		}))
+		prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "wfseye_view_cache_entries",
+			Help:        "Entries in the view cache",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, _, size := cache.cache().Stats()
+			return float64(size)
+		}))
+	}
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "wfseye_response_cache_bytes",
+		Help: "Bytes of upstream responses in the response cache",
+	}, func() float64 {
+		_, _, size := responseCache.Stats()
+		return float64(size)
+	}))
+
+	h.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
+
+//instrument counts, times and traces requests to a features API endpoint
+func instrument(endpoint string, handler func(*gin.Context)) func(*gin.Context) {
+	return func(c *gin.Context) {
+		start := time.Now()
+		span := traceRequest(c, endpoint)
+		handler(c)
+
+		view := metricsView(c)
+		span.SetAttributes(attribute.String("wfseye.view", view))
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(c.Writer.Status())...)
+		//4xx are errors of the client, not of the server
+		if c.Writer.Status() >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+		span.End()
+
+		status := strconv.Itoa(c.Writer.Status())
+		requestsTotal.WithLabelValues(endpoint, view, status).Inc()
+		requestDuration.WithLabelValues(endpoint, view, status).Observe(time.Since(start).Seconds())
+		size := c.Writer.Size()
+		if size > 0 {
+			responseBytes.WithLabelValues(endpoint, view).Add(float64(size))
+		}
+	}
+}
+
+//metricsView is the 'view' label of a request, set by authorizeCollection. Only views are
+//used as labels so that requests to random collection names can't create new series
+func metricsView(c *gin.Context) string {
+	return c.GetString("view")
+}
+
+//observeMongo records the duration of a Mongo operation started at start. Use it with defer
+func observeMongo(operation string, start time.Time) {
+	mongoDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+)
+
+//pagingParams are forwarded untouched through the view chain to the upstream WFS
+var pagingParams = []string{"offset", "startindex", "startIndex", "cursor", "token"}
+
+//pagingRels are the upstream links that are kept, pointing back to wfs-eye
+var pagingRels = []string{"next", "prev", "previous", "first", "last"}
+
+//rewritePagingLinks replaces upstream links by links to the wfs-eye collection requested by the client.
+//Only the paging params are taken from each upstream link. All other params come from the client request,
+//so the view chain is applied again on the next page. Other params upstream adds to its links (like 'f=json')
+//are dropped, as wfs-eye would take them for property filters
+func rewritePagingLinks(links []Link, base string, requestURL *url.URL) []Link {
+	result := []Link{
+		{Href: fmt.Sprintf("%s%s", base, requestURL.RequestURI()), Rel: "self", Type: "application/geo+json", Title: "This document"},
+	}
+	for _, l := range links {
+		if !containsString(pagingRels, l.Rel) {
+			continue
+		}
+		lu, err := url.Parse(l.Href)
+		if err != nil {
+			continue
+		}
+
+		paging := url.Values{}
+		for k, vs := range lu.Query() {
+			if containsString(pagingParams, k) {
+				paging[k] = vs
+			}
+		}
+
+		q := url.Values{}
+		for k, vs := range requestURL.Query() {
+			if containsString(pagingParams, k) {
+				continue
+			}
+			q[k] = vs
+		}
+		for k, vs := range paging {
+			q[k] = vs
+		}
+
+		href := fmt.Sprintf("%s%s", base, requestURL.Path)
+		if len(q) > 0 {
+			href = fmt.Sprintf("%s?%s", href, q.Encode())
+		}
+		result = append(result, Link{Href: href, Rel: l.Rel, Type: "application/geo+json", Title: l.Title})
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+//hiddenPropertyError is returned when a query filters by a property that a view doesn't expose
+type hiddenPropertyError struct {
+	property string
+	view     string
+}
+
+func (e *hiddenPropertyError) Error() string {
+	return fmt.Sprintf("Property %s is not available in view %s", e.property, e.view)
+}
+
+//validatePropertyProjection checks 'includeProperties', 'excludeProperties' and 'renameProperties' of a view
+func validatePropertyProjection(view View) error {
+	if view.IncludeProperties != nil && view.ExcludeProperties != nil {
+		return fmt.Errorf("'includeProperties' and 'excludeProperties' can't be used together")
+	}
+	if view.RenameProperties != nil {
+		targets := make(map[string]string)
+		for from, to := range *view.RenameProperties {
+			if from == "" || to == "" {
+				return fmt.Errorf("'renameProperties' names must not be empty")
+			}
+			other, ok := targets[to]
+			if ok {
+				return fmt.Errorf("'renameProperties' renames both %s and %s to %s", other, from, to)
+			}
+			targets[to] = from
+		}
+	}
+	return nil
+}
+
+//hasPropertyProjection tells whether a view changes the properties of its features
+func hasPropertyProjection(view View) bool {
+	return view.IncludeProperties != nil || view.ExcludeProperties != nil || view.RenameProperties != nil
+}
+
+//viewExposesProperty tells whether name, as it is in the collection the view is based on, is sent by the view
+func viewExposesProperty(view View, name string) bool {
+	if view.IncludeProperties != nil && !containsString(*view.IncludeProperties, name) {
+		return false
+	}
+	if view.ExcludeProperties != nil && containsString(*view.ExcludeProperties, name) {
+		return false
+	}
+	return true
+}
+
+//projectProperties removes the properties the view doesn't expose and renames the others.
+//Names in 'includeProperties' and 'excludeProperties' are the names before renaming
+func projectProperties(view View, f *geojson.Feature) {
+	if !hasPropertyProjection(view) || f.Properties == nil {
+		return
+	}
+	props := make(geojson.Properties, len(f.Properties))
+	for k, v := range f.Properties {
+		if !viewExposesProperty(view, k) {
+			continue
+		}
+		if view.RenameProperties != nil {
+			to, ok := (*view.RenameProperties)[k]
+			if ok {
+				k = to
+			}
+		}
+		props[k] = v
+	}
+	f.Properties = props
+}
+
+//sourcePropertyName translates a property name as sent by view to its name in the collection the view is
+//based on. false is returned if the view doesn't send a property with this name
+func sourcePropertyName(view View, name string) (string, bool) {
+	source := name
+	if view.RenameProperties != nil {
+		renamed := false
+		for from, to := range *view.RenameProperties {
+			if to == name {
+				source = from
+				renamed = true
+				break
+			}
+		}
+		//the original name was replaced by the new one
+		_, renamedAway := (*view.RenameProperties)[name]
+		if !renamed && renamedAway {
+			return "", false
+		}
+	}
+	if !viewExposesProperty(view, source) {
+		return "", false
+	}
+	return source, true
+}
+
+//translatePropertiesFilter rewrites the property filters sent to a view with the names of the collection
+//the view is based on, so that they work on upstream. Filtering by a property hidden by the view is an error,
+//otherwise clients could find out its values from the features returned
+func translatePropertiesFilter(view View, propertiesFilterStr string) (string, error) {
+	if !hasPropertyProjection(view) {
+		return propertiesFilterStr, nil
+	}
+	values, err := url.ParseQuery(propertiesFilterStr)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	translated := ""
+	for _, k := range keys {
+		source, ok := sourcePropertyName(view, k)
+		if !ok {
+			return "", &hiddenPropertyError{property: k, view: *view.Name}
+		}
+		for _, v := range values[k] {
+			translated = fmt.Sprintf("%s&%s=%s", translated, url.QueryEscape(source), url.QueryEscape(v))
+		}
+	}
+	return translated, nil
+}
+
+//parsePropertiesParam parses the 'properties' query parameter. nil means all properties
+func parsePropertiesParam(propertiesstr string) []string {
+	if propertiesstr == "" {
+		return nil
+	}
+	names := make([]string, 0)
+	for _, name := range strings.Split(propertiesstr, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//selectProperties keeps only the properties in names. Names the feature doesn't have are ignored
+func selectProperties(names []string, f *geojson.Feature) {
+	if names == nil || f.Properties == nil {
+		return
+	}
+	props := make(geojson.Properties, len(names))
+	for _, name := range names {
+		v, ok := f.Properties[name]
+		if ok {
+			props[name] = v
+		}
+	}
+	f.Properties = props
+}
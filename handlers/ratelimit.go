@@ -0,0 +1,376 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	rateLimits rateLimitStore
+	//trustedProxies are the networks of reverse proxies whose X-Forwarded-For header is used to find the client IP
+	trustedProxies []*net.IPNet
+)
+
+//RateLimitOptions are the limits applied to each client, identified by its API key or IP
+type RateLimitOptions struct {
+	//TrustedProxies are the IPs or CIDRs of reverse proxies in front of wfs-eye. The client IP is taken from
+	//X-Forwarded-For only for requests coming from them
+	TrustedProxies []string
+	//RequestsPerMinute is the refill rate of the token bucket of each client. 0 disables it
+	RequestsPerMinute int
+	//Burst is the token bucket size. 0 means RequestsPerMinute
+	Burst int
+	//FeaturesPerDay is the max number of features returned to each client per UTC day. 0 disables it
+	FeaturesPerDay int
+	//AuthFailuresPerMinute is the max number of requests with rejected credentials per client IP per minute
+	//to views with 'access'. 0 disables it
+	AuthFailuresPerMinute int
+	//Store is 'memory' or 'mongo'. With 'mongo' the limits hold across replicas
+	Store string
+}
+
+//ViewRateLimit overrides the global limits for requests to a view.
+//Clients get counters for the view that are separate from the global ones
+type ViewRateLimit struct {
+	RequestsPerMinute *int `json:"requestsPerMinute,omitempty" bson:"requestsPerMinute,omitempty"`
+	Burst             *int `json:"burst,omitempty" bson:"burst,omitempty"`
+	FeaturesPerDay    *int `json:"featuresPerDay,omitempty" bson:"featuresPerDay,omitempty"`
+}
+
+//rateLimitStore keeps rate limit counters
+type rateLimitStore interface {
+	//take removes a token from the bucket of key. The bucket gets a token each interval and holds up to burst tokens.
+	//It returns how long until a token is available if the bucket is empty or 0 if a token was taken
+	take(key string, interval time.Duration, burst int) (time.Duration, error)
+	//usage returns the amount counted for key
+	usage(key string) (int64, error)
+	//add increments the amount counted for key. The counter is dropped after expires
+	add(key string, n int64, expires time.Time) error
+}
+
+func newRateLimitStore(opt Options) (rateLimitStore, error) {
+	rl := opt.RateLimit
+	switch rl.Store {
+	case "", "memory":
+		return newMemoryRateLimitStore(), nil
+	case "mongo":
+		return newMongoRateLimitStore(opt)
+	default:
+		return nil, fmt.Errorf("Unknown rate limit store '%s'. Use 'memory' or 'mongo'", rl.Store)
+	}
+}
+
+func (v *ViewRateLimit) validate() error {
+	for name, value := range map[string]*int{"requestsPerMinute": v.RequestsPerMinute, "burst": v.Burst, "featuresPerDay": v.FeaturesPerDay} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("'%s' cannot be negative", name)
+		}
+	}
+	return nil
+}
+
+//clientLimits are the limits that apply to a request
+type clientLimits struct {
+	requestsKey       string
+	requestsPerMinute int
+	burst             int
+	featuresKey       string
+	featuresPerDay    int
+}
+
+//limitsFor merges the global limits with the limits of the view, if collection is one
+func limitsFor(c *gin.Context, collection string) clientLimits {
+	view, err := findView(c.Request.Context(), collection)
+	if err != nil {
+		view = View{}
+	}
+
+	//only API keys of the view identify clients. Otherwise any random key would get a new bucket
+	client := "ip:" + clientIP(c.Request)
+	cons := consumer{apiKey: c.GetHeader("X-API-Key")}
+	if cons.validAPIKey(view) {
+		//API keys are secrets. Don't keep them in the store
+		client = "key:" + hashAPIKey(cons.apiKey)
+	}
+
+	rl := opt.RateLimit
+	l := clientLimits{
+		requestsKey:       "requests:" + client,
+		requestsPerMinute: rl.RequestsPerMinute,
+		burst:             rl.Burst,
+		featuresKey:       "features:" + client,
+		featuresPerDay:    rl.FeaturesPerDay,
+	}
+	if view.RateLimit == nil {
+		return l
+	}
+	vrl := view.RateLimit
+	if vrl.RequestsPerMinute != nil || vrl.Burst != nil {
+		l.requestsKey = fmt.Sprintf("requests:%s:%s", collection, client)
+		if vrl.RequestsPerMinute != nil {
+			l.requestsPerMinute = *vrl.RequestsPerMinute
+		}
+		if vrl.Burst != nil {
+			l.burst = *vrl.Burst
+		}
+	}
+	if vrl.FeaturesPerDay != nil {
+		l.featuresKey = fmt.Sprintf("features:%s:%s", collection, client)
+		l.featuresPerDay = *vrl.FeaturesPerDay
+	}
+	return l
+}
+
+//checkRateLimit takes a request token and checks the feature quota of the client.
+//If a limit was reached, 429 is sent and false is returned
+func checkRateLimit(c *gin.Context, l clientLimits) bool {
+	if rateLimits == nil {
+		return true
+	}
+	if l.requestsPerMinute > 0 {
+		burst := l.burst
+		if burst <= 0 {
+			burst = l.requestsPerMinute
+		}
+		wait, err := rateLimits.take(l.requestsKey, time.Minute/time.Duration(l.requestsPerMinute), burst)
+		if err != nil {
+			//don't refuse clients because the counters are unavailable
+			logrus.Warnf("Error checking rate limit. err=%s", err)
+		} else if wait > 0 {
+			sendTooManyRequests(c, wait, fmt.Sprintf("Rate limit of %d requests per minute exceeded", l.requestsPerMinute))
+			return false
+		}
+	}
+	if l.featuresPerDay > 0 {
+		used, err := rateLimits.usage(quotaKey(l.featuresKey))
+		if err != nil {
+			logrus.Warnf("Error checking feature quota. err=%s", err)
+		} else if used >= int64(l.featuresPerDay) {
+			sendTooManyRequests(c, time.Until(quotaReset()), fmt.Sprintf("Quota of %d features per day exceeded", l.featuresPerDay))
+			return false
+		}
+	}
+	return true
+}
+
+//authFailuresKey is the counter of rejected credentials of the client IP in the current minute and when it resets
+func authFailuresKey(c *gin.Context) (string, time.Time) {
+	now := time.Now().UTC()
+	return fmt.Sprintf("authfailures:ip:%s:%s", clientIP(c.Request), now.Format("2006-01-02T15:04")), now.Truncate(time.Minute).Add(time.Minute)
+}
+
+//checkAuthFailures refuses clients that sent too many rejected credentials in the last minute, before
+//their credentials are checked, so that API keys can't be guessed. If so, 429 is sent and false is returned
+func checkAuthFailures(c *gin.Context) bool {
+	max := opt.RateLimit.AuthFailuresPerMinute
+	if rateLimits == nil || max <= 0 {
+		return true
+	}
+	key, reset := authFailuresKey(c)
+	failures, err := rateLimits.usage(key)
+	if err != nil {
+		logrus.Warnf("Error checking authentication failures. err=%s", err)
+		return true
+	}
+	if failures >= int64(max) {
+		sendTooManyRequests(c, time.Until(reset), fmt.Sprintf("Too many requests with invalid credentials. Max %d per minute", max))
+		return false
+	}
+	return true
+}
+
+//countAuthFailure adds a request with rejected credentials to the counter of the client IP
+func countAuthFailure(c *gin.Context) {
+	if rateLimits == nil || opt.RateLimit.AuthFailuresPerMinute <= 0 {
+		return
+	}
+	key, reset := authFailuresKey(c)
+	err := rateLimits.add(key, 1, reset)
+	if err != nil {
+		logrus.Warnf("Error counting authentication failure. err=%s", err)
+	}
+}
+
+//countFeatures adds features sent to a client to its daily quota
+func countFeatures(l clientLimits, n int) {
+	if rateLimits == nil || l.featuresPerDay <= 0 || n == 0 {
+		return
+	}
+	err := rateLimits.add(quotaKey(l.featuresKey), int64(n), quotaReset().Add(time.Hour))
+	if err != nil {
+		logrus.Warnf("Error counting features for quota. err=%s", err)
+	}
+}
+
+//parseTrustedProxies parses a list of IPs and CIDRs
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP '%s'", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//clientIP is the address of the connection. If it comes from a trusted proxy, the last address of
+//X-Forwarded-For that isn't a trusted proxy is used, as the ones before it can be sent by the client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		fip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if fip == nil {
+			break
+		}
+		if !trustedProxy(fip) {
+			return fip.String()
+		}
+	}
+	return host
+}
+
+func sendTooManyRequests(c *gin.Context, wait time.Duration, message string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"message": message})
+}
+
+//quotaKey is the key of the counter of the current UTC day
+func quotaKey(key string) string {
+	return key + ":" + time.Now().UTC().Format("2006-01-02")
+}
+
+//quotaReset is the start of the next UTC day
+func quotaReset() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+}
+
+//memoryRateLimitStore keeps counters of this instance only. Token buckets are implemented
+//with GCRA, where each key stores the time at which its bucket will be full again
+type memoryRateLimitStore struct {
+	mutex     sync.Mutex
+	tats      map[string]time.Time
+	counters  map[string]*memoryCounter
+	nextSweep time.Time
+}
+
+type memoryCounter struct {
+	n       int64
+	expires time.Time
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{
+		tats:     make(map[string]time.Time),
+		counters: make(map[string]*memoryCounter),
+	}
+}
+
+func (s *memoryRateLimitStore) take(key string, interval time.Duration, burst int) (time.Duration, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	wait, tat := nextTAT(s.tats[key], now, interval, burst)
+	if wait > 0 {
+		return wait, nil
+	}
+	s.tats[key] = tat
+	return 0, nil
+}
+
+func (s *memoryRateLimitStore) usage(key string) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cn, ok := s.counters[key]
+	if !ok || time.Now().After(cn.expires) {
+		return 0, nil
+	}
+	return cn.n, nil
+}
+
+func (s *memoryRateLimitStore) add(key string, n int64, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cn, ok := s.counters[key]
+	if !ok || time.Now().After(cn.expires) {
+		cn = &memoryCounter{}
+		s.counters[key] = cn
+	}
+	cn.n += n
+	cn.expires = expires
+	return nil
+}
+
+//sweep drops full buckets and expired counters once a minute so that memory doesn't grow with the number of clients
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(time.Minute)
+	for k, tat := range s.tats {
+		if tat.Before(now) {
+			delete(s.tats, k)
+		}
+	}
+	for k, cn := range s.counters {
+		if now.After(cn.expires) {
+			delete(s.counters, k)
+		}
+	}
+}
+
+//nextTAT applies GCRA. tat is the time at which the bucket will be full again. Taking a token moves it
+//interval ahead and is allowed while it stays within burst intervals from now
+func nextTAT(tat time.Time, now time.Time, interval time.Duration, burst int) (time.Duration, time.Time) {
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval)
+	limit := now.Add(interval * time.Duration(burst))
+	if newTAT.After(limit) {
+		return newTAT.Sub(limit), tat
+	}
+	return 0, newTAT
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//mongoRateLimitStore shares counters among replicas in the 'ratelimits' collection.
+//Documents are removed by a TTL index when they are not needed anymore
+type mongoRateLimitStore struct {
+	session *mgo.Session
+	dbName  string
+}
+
+func newMongoRateLimitStore(opt Options) (rateLimitStore, error) {
+	session, err := dialMongo(opt)
+	if err != nil {
+		return nil, err
+	}
+	s := &mongoRateLimitStore{session: session, dbName: opt.MongoDBName}
+	sc := s.session.Copy()
+	defer sc.Close()
+	err = sc.DB(s.dbName).C("ratelimits").EnsureIndex(mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Error creating rate limits TTL index. err=%s", err)
+	}
+	return s, nil
+}
+
+func (s *mongoRateLimitStore) take(key string, interval time.Duration, burst int) (time.Duration, error) {
+	defer observeMongo("ratelimits.take", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("ratelimits")
+
+	//optimistic concurrency. the update only succeeds if no other replica changed the bucket since it was read
+	for i := 0; i < 10; i++ {
+		var doc struct {
+			TAT time.Time `bson:"tat"`
+		}
+		exists := true
+		err := st.FindId(key).One(&doc)
+		if err == mgo.ErrNotFound {
+			exists = false
+		} else if err != nil {
+			return 0, err
+		}
+
+		wait, tat := nextTAT(doc.TAT, time.Now(), interval, burst)
+		if wait > 0 {
+			return wait, nil
+		}
+
+		if !exists {
+			err = st.Insert(bson.M{"_id": key, "tat": tat, "expires": tat})
+			if mgo.IsDup(err) {
+				continue
+			}
+			return 0, err
+		}
+		err = st.Update(bson.M{"_id": key, "tat": doc.TAT}, bson.M{"$set": bson.M{"tat": tat, "expires": tat}})
+		if err == mgo.ErrNotFound {
+			continue
+		}
+		return 0, err
+	}
+	return 0, fmt.Errorf("Too many concurrent updates of rate limit %s", key)
+}
+
+func (s *mongoRateLimitStore) usage(key string) (int64, error) {
+	defer observeMongo("ratelimits.usage", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("ratelimits")
+
+	var doc struct {
+		N int64 `bson:"n"`
+	}
+	err := st.FindId(key).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return doc.N, nil
+}
+
+func (s *mongoRateLimitStore) add(key string, n int64, expires time.Time) error {
+	defer observeMongo("ratelimits.add", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("ratelimits")
+
+	_, err := st.UpsertId(key, bson.M{"$inc": bson.M{"n": n}, "$set": bson.M{"expires": expires}})
+	return err
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+//responseCache keeps upstream feature responses by upstream URL. Its size is in bytes
+var responseCache *lruCache
+
+//cachedResponse is an upstream features response body with the headers used for validation by clients
+type cachedResponse struct {
+	body         []byte
+	etag         string
+	lastModified string
+	expires      time.Time
+	//views are the names of the views that resolved to this query, so that changing any of them drops it
+	views []string
+}
+
+//cachingBody keeps a copy of an upstream response body while it is streamed.
+//The copy is dropped if it gets bigger than max
+type cachingBody struct {
+	io.ReadCloser
+	buf   *bytes.Buffer
+	max   int
+	store func(body []byte)
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.buf != nil {
+		if b.buf.Len()+n > b.max {
+			b.buf = nil
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+//commit stores the body if it fits in the cache. Called after the whole FeatureCollection was parsed
+func (b *cachingBody) commit() {
+	if b.buf == nil {
+		return
+	}
+	//the decoder may stop reading right after the closing brace
+	io.Copy(ioutil.Discard, io.LimitReader(b, int64(b.max)))
+	if b.buf == nil {
+		return
+	}
+	b.store(b.buf.Bytes())
+}
+
+//responseCacheTTL is the 'cacheTTL' of the outermost view of the chain that has one or the global TTL
+func responseCacheTTL(ctx context.Context, viewNames []string) time.Duration {
+	for _, name := range viewNames {
+		view, err := findView(ctx, name)
+		if err != nil || view.CacheTTL == nil {
+			continue
+		}
+		ttl, err := time.ParseDuration(*view.CacheTTL)
+		if err == nil {
+			return ttl
+		}
+	}
+	return opt.ResponseCacheTTL
+}
+
+//fetchUpstreamFeatures opens a stream of the features returned by q. viewNames are the
+//views that resolved to q, outermost first. Responses are served from and kept in responseCache
+func fetchUpstreamFeatures(ctx context.Context, u *upstreamClient, q string, viewNames []string) (*featureStream, error) {
+	ttl := responseCacheTTL(ctx, viewNames)
+	key := q
+	if len(viewNames) > 0 {
+		key = viewNames[0] + " " + q
+	}
+	//ttl is still sent to clients in Cache-Control when the cache is disabled
+	cacheable := ttl > 0 && opt.ResponseCacheSize > 0
+
+	if cacheable {
+		v, ok := responseCache.Get(key)
+		if !ok {
+			responseCacheRequests.WithLabelValues("miss").Inc()
+		} else {
+			responseCacheRequests.WithLabelValues("hit").Inc()
+			cr := v.(*cachedResponse)
+			logrus.Debugf("WFS response found in cache. Streaming features")
+			fs, err := newFeatureStream(ioutil.NopCloser(bytes.NewReader(cr.body)))
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing cached WFS service response. err=%s", err)
+			}
+			fs.etag = cr.etag
+			fs.lastModified = cr.lastModified
+			fs.maxAge = time.Until(cr.expires)
+			return fs, nil
+		}
+	}
+
+	logrus.Debugf("WFS query: %s", q)
+	resp, err := u.Get(ctx, q)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data1, err1 := ioutil.ReadAll(resp.Body)
+		if err1 != nil {
+			return nil, fmt.Errorf("WFS invocation status != 200. status=%d. body=[failed to get contents]. err=%s", resp.StatusCode, err1)
+		}
+		return nil, fmt.Errorf("WFS invocation error. status=%d. body=%s", resp.StatusCode, string(data1))
+	}
+
+	etag := resp.Header.Get("ETag")
+	lastModified := resp.Header.Get("Last-Modified")
+	body := resp.Body
+	var cb *cachingBody
+	if cacheable {
+		cb = &cachingBody{
+			ReadCloser: resp.Body,
+			buf:        &bytes.Buffer{},
+			//a single response can't take more than a tenth of the cache
+			max: opt.ResponseCacheSize / 10,
+			store: func(data []byte) {
+				//the validators are the upstream ones, as for responses not served from the cache
+				cr := &cachedResponse{
+					body:         data,
+					etag:         etag,
+					lastModified: lastModified,
+					expires:      time.Now().Add(ttl),
+					views:        viewNames,
+				}
+				responseCache.SetWithCost(key, cr, len(data), ttl)
+				logrus.Debugf("WFS response cached. size=%d", len(data))
+			},
+		}
+		body = cb
+	}
+
+	fs, err := newFeatureStream(body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+	}
+	fs.cache = cb
+	fs.etag = etag
+	fs.lastModified = lastModified
+	fs.maxAge = ttl
+	logrus.Debugf("WFS response OK. Streaming features")
+	return fs, nil
+}
+
+//invalidateResponses drops cached responses fetched through a view
+func invalidateResponses(viewName string) {
+	responseCache.DeleteFunc(func(v interface{}) bool {
+		return containsString(v.(*cachedResponse).views, viewName)
+	})
+}
+
+//notModified answers 304 for conditional requests whose response is in responseCache and didn't change,
+//before going upstream. The query is resolved as for explain to find the upstream URL without calling upstream
+func notModified(c *gin.Context, collection string, bboxstr string, limitstr string, timestr string, pagingstr string, propertiesFilterStr string) bool {
+	if opt.ResponseCacheSize <= 0 || (c.GetHeader("If-None-Match") == "" && c.GetHeader("If-Modified-Since") == "") {
+		return false
+	}
+	ctx := c.Request.Context()
+	ex := &queryExplanation{Collection: collection, Steps: make([]explainStep, 0)}
+	_, err := resolveFeatureCollection(ctx, collection, bboxstr, limitstr, timestr, pagingstr, propertiesFilterStr, make([]string, 0), ex)
+	if err != nil {
+		//the error is reported when the query is resolved again
+		return false
+	}
+	chain, _, err := resolveViewChain(ctx, collection)
+	if err != nil {
+		return false
+	}
+	//same key as fetchUpstreamFeatures
+	key := ex.UpstreamURL
+	if len(chain) > 0 {
+		key = collection + " " + key
+	}
+	v, ok := responseCache.Get(key)
+	if !ok {
+		return false
+	}
+	cr := v.(*cachedResponse)
+	fs := &featureStream{views: chain, etag: cr.etag, lastModified: cr.lastModified, maxAge: time.Until(cr.expires)}
+	if !setCacheHeaders(c, fs) {
+		return false
+	}
+	responseCacheRequests.WithLabelValues("hit").Inc()
+	logrus.Debugf("Response not modified. Upstream not called")
+	return true
+}
+
+//setCacheHeaders sends validators and Cache-Control for a features response. If the
+//client already has this response, 304 is sent and true is returned
+func setCacheHeaders(c *gin.Context, fs *featureStream) bool {
+	restricted := false
+	var viewsUpdate time.Time
+	for _, v := range fs.views {
+		if v.Access != nil {
+			restricted = true
+		}
+		if v.LastUpdate.After(viewsUpdate) {
+			viewsUpdate = v.LastUpdate
+		}
+	}
+
+	//restricted responses must not be kept by shared caches
+	visibility := "public"
+	if restricted {
+		visibility = "private"
+	}
+	if fs.maxAge > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(fs.maxAge.Seconds())))
+	} else if restricted {
+		c.Header("Cache-Control", "private")
+	}
+
+	//the response depends on the upstream data, the views and the request
+	etag := ""
+	if fs.etag != "" {
+		h := sha1.New()
+		fmt.Fprintf(h, "%s %s %d", fs.etag, c.Request.URL.String(), viewsUpdate.UnixNano())
+		etag = `W/"` + hex.EncodeToString(h.Sum(nil)) + `"`
+		c.Header("ETag", etag)
+	}
+	var lastModified time.Time
+	if fs.lastModified != "" {
+		lm, err := http.ParseTime(fs.lastModified)
+		if err == nil {
+			lastModified = lm
+			if viewsUpdate.After(lastModified) {
+				lastModified = viewsUpdate
+			}
+			c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	inm := c.GetHeader("If-None-Match")
+	if inm != "" {
+		if etag != "" && etagMatches(inm, etag) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+		return false
+	}
+	ims := c.GetHeader("If-Modified-Since")
+	if ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !lastModified.Truncate(time.Second).After(t) {
+			c.Status(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+//etagMatches does the weak comparison of If-None-Match
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/simplify"
+)
+
+const (
+	simplifyDouglasPeucker     = "douglas-peucker"
+	simplifyTopologyPreserving = "topology-preserving"
+
+	//maxZoom is the highest 'zoom' accepted. Its tolerance is already below the precision of most data
+	maxZoom = 24
+	//maxPrecision is the highest 'coordinatePrecision'. float64 can't hold more decimals of a coordinate
+	maxPrecision = 15
+)
+
+//generalization is how returned geometries are simplified and rounded, merged from the view chain and 'zoom'
+type generalization struct {
+	//tolerance is the simplification tolerance in degrees (CRS84). 0 disables simplification
+	tolerance        float64
+	preserveTopology bool
+	//precision is the number of decimals of returned coordinates. -1 keeps them as they are
+	precision int
+}
+
+//validateGeneralization checks 'simplifyTolerance', 'simplifyMethod' and 'coordinatePrecision' of a view
+func validateGeneralization(view View) error {
+	if view.SimplifyTolerance != nil && *view.SimplifyTolerance < 0 {
+		return fmt.Errorf("'simplifyTolerance' must not be negative")
+	}
+	if view.SimplifyMethod != nil {
+		if *view.SimplifyMethod != simplifyDouglasPeucker && *view.SimplifyMethod != simplifyTopologyPreserving {
+			return fmt.Errorf("'simplifyMethod' must be '%s' or '%s'", simplifyDouglasPeucker, simplifyTopologyPreserving)
+		}
+		if view.SimplifyTolerance == nil {
+			return fmt.Errorf("'simplifyMethod' requires 'simplifyTolerance'")
+		}
+	}
+	if view.CoordinatePrecision != nil && (*view.CoordinatePrecision < 0 || *view.CoordinatePrecision > maxPrecision) {
+		return fmt.Errorf("'coordinatePrecision' must be between 0 and %d", maxPrecision)
+	}
+	return nil
+}
+
+//zoomTolerance is the size of a pixel in degrees of a 256px tile at a web map zoom level, so that
+//simplification removes details that wouldn't be visible at that zoom
+func zoomTolerance(zoom float64) float64 {
+	return 360 / (256 * math.Pow(2, zoom))
+}
+
+//parseZoom parses the 'zoom' query parameter. It returns -1 if zoomstr is empty
+func parseZoom(zoomstr string) (float64, error) {
+	if zoomstr == "" {
+		return -1, nil
+	}
+	zoom, err := strconv.ParseFloat(zoomstr, 64)
+	if err != nil || zoom < 0 || zoom > maxZoom {
+		return 0, fmt.Errorf("It must be a number between 0 and %d", maxZoom)
+	}
+	return zoom, nil
+}
+
+//newGeneralization merges the generalization of the views of a chain with the 'zoom' query parameter.
+//The biggest tolerance and the smallest precision win, so that a view can't be queried with more detail
+//than the views it is based on. zoom is ignored if negative
+func newGeneralization(views []View, zoom float64) *generalization {
+	g := &generalization{precision: -1}
+	for _, view := range views {
+		if view.SimplifyTolerance != nil {
+			g.tolerance = math.Max(g.tolerance, *view.SimplifyTolerance)
+			if view.SimplifyMethod != nil && *view.SimplifyMethod == simplifyTopologyPreserving {
+				g.preserveTopology = true
+			}
+		}
+		if view.CoordinatePrecision != nil && (g.precision == -1 || *view.CoordinatePrecision < g.precision) {
+			g.precision = *view.CoordinatePrecision
+		}
+	}
+	if zoom >= 0 {
+		g.tolerance = math.Max(g.tolerance, zoomTolerance(zoom))
+	}
+	return g
+}
+
+//simplifyFeature simplifies the geometry of a feature in CRS84. If simplification fails or would
+//collapse the geometry, it is kept as it is
+func (g *generalization) simplifyFeature(f *geojson.Feature) error {
+	if g == nil || g.tolerance == 0 || f.Geometry == nil || f.Geometry.Dimensions() == 0 {
+		return nil
+	}
+	var simplified orb.Geometry
+	if g.preserveTopology {
+		og, err := toGEOS(f.Geometry)
+		if err != nil {
+			return err
+		}
+		sg, err := og.SimplifyP(g.tolerance)
+		if err != nil {
+			return err
+		}
+		simplified, err = fromGEOS(sg)
+		if err != nil {
+			return err
+		}
+	} else {
+		//the simplifier changes the geometry in place
+		simplified = simplify.DouglasPeucker(g.tolerance).Simplify(orb.Clone(f.Geometry))
+	}
+	if collapsed(simplified) {
+		return nil
+	}
+	f.Geometry = simplified
+	if f.BBox != nil {
+		f.BBox = geojson.NewBBox(f.Geometry.Bound())
+	}
+	return nil
+}
+
+//collapsed tells whether a simplified geometry lost all of its lines or areas
+func collapsed(g orb.Geometry) bool {
+	switch gg := g.(type) {
+	case nil:
+		return true
+	case orb.LineString:
+		return len(gg) < 2
+	case orb.MultiLineString:
+		return len(gg) == 0
+	case orb.Polygon:
+		return len(gg) == 0 || len(gg[0]) < 4
+	case orb.MultiPolygon:
+		return len(gg) == 0
+	}
+	return false
+}
+
+//roundFeature rounds the coordinates of a feature to 'coordinatePrecision' decimals in the CRS it is sent in
+func (g *generalization) roundFeature(f *geojson.Feature) {
+	if g == nil || g.precision < 0 || f.Geometry == nil {
+		return
+	}
+	factor := int(math.Pow10(g.precision))
+	f.Geometry = orb.Round(f.Geometry, factor)
+	if f.BBox != nil {
+		f.BBox = geojson.NewBBox(f.Geometry.Bound())
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	//ErrViewNotFound is returned by a ViewStore when there is no view with the requested name
+	ErrViewNotFound = errors.New("View not found")
+	//ErrViewExists is returned by a ViewStore when creating a view whose name is already in use
+	ErrViewExists = errors.New("Duplicate view name")
+)
+
+//ViewStore persists View definitions
+type ViewStore interface {
+	Get(name string) (View, error)
+	List() ([]View, error)
+	Create(view View) error
+	//Update sets the non empty fields of view on the existing view with the given name
+	Update(name string, view View) error
+	Delete(name string) error
+}
+
+func newViewStore(opt Options) (ViewStore, error) {
+	switch opt.ViewStoreType {
+	case "", "mongo":
+		return NewMongoViewStore(opt)
+	case "file":
+		return NewFileViewStore(opt.ViewStoreFile)
+	default:
+		return nil, fmt.Errorf("Unknown view store type '%s'. Use 'mongo' or 'file'", opt.ViewStoreType)
+	}
+}
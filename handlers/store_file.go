@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//fileViewStore keeps all views in memory and rewrites a single JSON file on every change.
+//It is meant for small deployments and CI where running MongoDB is not desirable
+type fileViewStore struct {
+	path  string
+	mutex sync.RWMutex
+	views map[string]View
+}
+
+//NewFileViewStore loads views from a JSON file. The file is created on the first change if it doesn't exist
+func NewFileViewStore(path string) (ViewStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("View store file path is required")
+	}
+	s := &fileViewStore{path: path, views: make(map[string]View)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logrus.Infof("View store file %s not found. Starting with no views", path)
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading view store file %s. err=%s", path, err)
+	}
+
+	views := make([]View, 0)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &views)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing view store file %s. err=%s", path, err)
+		}
+	}
+	for _, v := range views {
+		if v.Name == nil {
+			continue
+		}
+		s.views[*v.Name] = v
+	}
+	logrus.Infof("Loaded %d views from %s", len(s.views), path)
+	return s, nil
+}
+
+func (s *fileViewStore) Get(name string) (View, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	view, ok := s.views[name]
+	if !ok {
+		return View{}, ErrViewNotFound
+	}
+	return view, nil
+}
+
+func (s *fileViewStore) List() ([]View, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	views := make([]View, 0, len(s.views))
+	for _, v := range s.views {
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool { return *views[i].Name < *views[j].Name })
+	return views, nil
+}
+
+func (s *fileViewStore) Create(view View) error {
+	if view.Name == nil {
+		return fmt.Errorf("'name' is required")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.views[*view.Name]
+	if ok {
+		return ErrViewExists
+	}
+	s.views[*view.Name] = view
+	err := s.save()
+	if err != nil {
+		delete(s.views, *view.Name)
+		return err
+	}
+	return nil
+}
+
+func (s *fileViewStore) Update(name string, view View) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, ok := s.views[name]
+	if !ok {
+		return ErrViewNotFound
+	}
+	updated, err := mergeView(current, view)
+	if err != nil {
+		return err
+	}
+	s.views[name] = updated
+	err = s.save()
+	if err != nil {
+		s.views[name] = current
+		return err
+	}
+	return nil
+}
+
+func (s *fileViewStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	current, ok := s.views[name]
+	if !ok {
+		return ErrViewNotFound
+	}
+	delete(s.views, name)
+	err := s.save()
+	if err != nil {
+		s.views[name] = current
+		return err
+	}
+	return nil
+}
+
+//save writes to a temp file and renames it so that a crash never leaves a half written file behind
+func (s *fileViewStore) save() error {
+	views := make([]View, 0, len(s.views))
+	for _, v := range s.views {
+		views = append(views, v)
+	}
+	sort.Slice(views, func(i, j int) bool { return *views[i].Name < *views[j].Name })
+	data, err := json.MarshalIndent(views, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing view store file. err=%s", err)
+	}
+	_, err = tmp.Write(data)
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing view store file. err=%s", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+//mergeView applies the same semantics as a Mongo '$set' with the view document:
+//only the fields present in update replace the ones in current
+func mergeView(current View, update View) (View, error) {
+	cm := bson.M{}
+	data, err := bson.Marshal(current)
+	if err != nil {
+		return View{}, err
+	}
+	err = bson.Unmarshal(data, &cm)
+	if err != nil {
+		return View{}, err
+	}
+
+	um := bson.M{}
+	data, err = bson.Marshal(update)
+	if err != nil {
+		return View{}, err
+	}
+	err = bson.Unmarshal(data, &um)
+	if err != nil {
+		return View{}, err
+	}
+
+	for k, v := range um {
+		cm[k] = v
+	}
+
+	data, err = bson.Marshal(cm)
+	if err != nil {
+		return View{}, err
+	}
+	var merged View
+	err = bson.Unmarshal(data, &merged)
+	if err != nil {
+		return View{}, err
+	}
+	return merged, nil
+}
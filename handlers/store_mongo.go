@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoViewStore struct {
+	session *mgo.Session
+	dbName  string
+}
+
+//NewMongoViewStore connects to MongoDB and stores views in the 'views' collection
+func NewMongoViewStore(opt Options) (ViewStore, error) {
+	mongoSession, err := dialMongo(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &mongoViewStore{session: mongoSession, dbName: opt.MongoDBName}, nil
+}
+
+//dialMongo connects to MongoDB retrying for a while so that wfs-eye can start along with Mongo
+func dialMongo(opt Options) (*mgo.Session, error) {
+	logrus.Debugf("Connecting to MongoDB")
+	mongoDBDialInfo := &mgo.DialInfo{
+		Addrs:    strings.Split(opt.MongoAddress, ","),
+		Timeout:  2 * time.Second,
+		Database: opt.MongoDBName,
+		Username: opt.MongoUsername,
+		Password: opt.MongoPassword,
+	}
+
+	var mongoSession *mgo.Session
+	for i := 0; i < 30; i++ {
+		ms, err := mgo.DialWithInfo(mongoDBDialInfo)
+		if err != nil {
+			logrus.Infof("Couldn't connect to mongdb. err=%s", err)
+			time.Sleep(1 * time.Second)
+			logrus.Infof("Retrying...")
+			continue
+		}
+		mongoSession = ms
+		logrus.Infof("Connected to MongoDB successfully")
+		break
+	}
+
+	if mongoSession == nil {
+		return nil, fmt.Errorf("Couldn't connect to MongoDB")
+	}
+	return mongoSession, nil
+}
+
+func (s *mongoViewStore) Get(name string) (View, error) {
+	defer observeMongo("views.get", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("views")
+
+	var view View
+	err := st.Find(bson.M{"name": name}).One(&view)
+	if err == mgo.ErrNotFound {
+		return View{}, ErrViewNotFound
+	}
+	if err != nil {
+		return View{}, err
+	}
+	return view, nil
+}
+
+func (s *mongoViewStore) List() ([]View, error) {
+	defer observeMongo("views.list", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("views")
+
+	views := make([]View, 0)
+	err := st.Find(nil).All(&views)
+	if err != nil {
+		return nil, err
+	}
+	return views, nil
+}
+
+func (s *mongoViewStore) Create(view View) error {
+	defer observeMongo("views.create", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("views")
+
+	//check duplicate
+	count, err := st.Find(bson.M{"name": view.Name}).Count()
+	if err != nil {
+		return fmt.Errorf("Error checking for existing view name. err=%s", err)
+	}
+	if count > 0 {
+		return ErrViewExists
+	}
+
+	return st.Insert(view)
+}
+
+func (s *mongoViewStore) Update(name string, view View) error {
+	defer observeMongo("views.update", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("views")
+
+	err := st.Update(bson.M{"name": name}, bson.M{"$set": view})
+	if err == mgo.ErrNotFound {
+		return ErrViewNotFound
+	}
+	return err
+}
+
+func (s *mongoViewStore) Delete(name string) error {
+	defer observeMongo("views.delete", time.Now())
+	sc := s.session.Copy()
+	defer sc.Close()
+	st := sc.DB(s.dbName).C("views")
+
+	err := st.Remove(bson.M{"name": name})
+	if err == mgo.ErrNotFound {
+		return ErrViewNotFound
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/sirupsen/logrus"
+)
+
+//featureStream decodes an upstream FeatureCollection one feature at a time so that
+//memory use doesn't depend on the response size. Top level members other than 'features'
+//(links, numberMatched...) are kept in members as they are found
+type featureStream struct {
+	body       io.ReadCloser
+	dec        *json.Decoder
+	inFeatures bool
+	done       bool
+	members    map[string]json.RawMessage
+
+	//views is the chain of views resolved for this query, outermost first
+	views []View
+	//cache keeps the response in responseCache once fully parsed. nil if it isn't cacheable
+	cache *cachingBody
+	//etag and lastModified are the upstream validators and maxAge the time the response can be cached
+	etag         string
+	lastModified string
+	maxAge       time.Duration
+	//crs is the CRS features are sent in. nil means CRS84
+	crs *crsDef
+	//generalization simplifies and rounds features. nil keeps them as they are
+	generalization *generalization
+	//properties are the properties requested with the 'properties' query parameter. nil means all
+	properties []string
+}
+
+func newFeatureStream(body io.ReadCloser) (*featureStream, error) {
+	s := &featureStream{
+		body:    body,
+		dec:     json.NewDecoder(bufio.NewReader(body)),
+		members: make(map[string]json.RawMessage),
+	}
+	err := s.expectDelim('{')
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//Next returns the next feature or nil after the last one
+func (s *featureStream) Next() (*geojson.Feature, error) {
+	for !s.done {
+		if s.inFeatures {
+			if s.dec.More() {
+				var raw json.RawMessage
+				err := s.dec.Decode(&raw)
+				if err != nil {
+					return nil, err
+				}
+				return geojson.UnmarshalFeature(raw)
+			}
+			err := s.expectDelim(']')
+			if err != nil {
+				return nil, err
+			}
+			s.inFeatures = false
+			continue
+		}
+
+		if !s.dec.More() {
+			err := s.expectDelim('}')
+			if err != nil {
+				return nil, err
+			}
+			s.done = true
+			break
+		}
+		t, err := s.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid FeatureCollection member %v", t)
+		}
+		if key == "features" {
+			t, err := s.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				//"features": null
+				continue
+			}
+			if d, ok := t.(json.Delim); !ok || d != '[' {
+				return nil, fmt.Errorf("Invalid 'features' member. It must be an array")
+			}
+			s.inFeatures = true
+			continue
+		}
+		var raw json.RawMessage
+		err = s.dec.Decode(&raw)
+		if err != nil {
+			return nil, err
+		}
+		s.members[key] = raw
+	}
+	return nil, nil
+}
+
+func (s *featureStream) Close() error {
+	if s.cache != nil && s.done {
+		s.cache.commit()
+	}
+	return s.body.Close()
+}
+
+func (s *featureStream) expectDelim(delim json.Delim) error {
+	t, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	d, ok := t.(json.Delim)
+	if !ok || d != delim {
+		return fmt.Errorf("Invalid FeatureCollection. Expected '%s' but found %v", delim, t)
+	}
+	return nil
+}
+
+//postProcessFeature applies view level processing to a feature returned by upstream, from the innermost
+//view to the outermost. It returns nil if the feature must not be sent to the client
+func postProcessFeature(views []View, f *geojson.Feature) *geojson.Feature {
+	for i := len(views) - 1; i >= 0 && f != nil; i-- {
+		f = postProcessView(views[i], f)
+	}
+	return f
+}
+
+//postProcessView applies the geometry restrictions and the property projection of a view to a feature.
+//It returns nil if the feature must not be sent to the client
+func postProcessView(view View, f *geojson.Feature) *geojson.Feature {
+	if view.ClipGeometries != nil && *view.ClipGeometries {
+		g, err := clipToView(view, f.Geometry)
+		if err != nil {
+			logrus.Warnf("Error clipping feature to view %s. Feature dropped. err=%s", *view.Name, err)
+			return nil
+		}
+		if g == nil {
+			return nil
+		}
+		f.Geometry = g
+	} else if view.MaxGeometry != nil {
+		//upstream was queried with the envelope of the polygon
+		ok, err := view.MaxGeometry.intersects(f.Geometry)
+		if err != nil {
+			logrus.Warnf("Error checking feature against view %s 'maxGeometry'. Feature dropped. err=%s", *view.Name, err)
+			return nil
+		}
+		if !ok {
+			return nil
+		}
+	}
+	projectProperties(view, f)
+	return f
+}
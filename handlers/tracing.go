@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//tracer delegates to the global provider, so spans are dropped while tracing is not set up
+var tracer = otel.Tracer("github.com/flaviostutz/wfs-eye/handlers")
+
+//TracingOptions configures the export of spans to an OpenTelemetry collector
+type TracingOptions struct {
+	//OTLPEndpoint is the host:port of an OTLP/HTTP collector. Empty disables tracing
+	OTLPEndpoint string
+	//OTLPInsecure sends spans with plain HTTP instead of HTTPS
+	OTLPInsecure bool
+	//SampleRatio is the fraction of traces started by wfs-eye that are recorded.
+	//Traces started by clients follow their sampling decision
+	SampleRatio float64
+	ServiceName string
+}
+
+//setupTracing makes trace context propagate from clients to upstreams and exports spans if an endpoint is set
+func setupTracing(topt TracingOptions) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if topt.OTLPEndpoint == "" {
+		return nil
+	}
+
+	eopts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(topt.OTLPEndpoint)}
+	if topt.OTLPInsecure {
+		eopts = append(eopts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), eopts...)
+	if err != nil {
+		return fmt.Errorf("Error creating OTLP exporter. err=%s", err)
+	}
+
+	serviceName := topt.ServiceName
+	if serviceName == "" {
+		serviceName = "wfs-eye"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(topt.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logrus.Debugf("Tracing error. err=%s", err)
+	}))
+	logrus.Infof("Exporting traces to %s", topt.OTLPEndpoint)
+	return nil
+}
+
+//traceRequest starts the server span of a request to endpoint, continuing the trace of the client if
+//it sent one. The request context carries the span so that the handler can add child spans to it
+func traceRequest(c *gin.Context, endpoint string) trace.Span {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, endpoint,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("wfs-eye", "", c.Request)...),
+	)
+	c.Request = c.Request.WithContext(ctx)
+	return span
+}
+
+//endSpan records err, if any, and ends span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+//viewStoreAttributes describe the view store in spans of its operations
+func viewStoreAttributes() []attribute.KeyValue {
+	if opt.ViewStoreType == "mongo" {
+		return []attribute.KeyValue{semconv.DBSystemMongoDB, semconv.DBNameKey.String(opt.MongoDBName), semconv.DBMongoDBCollectionKey.String("views")}
+	}
+	return []attribute.KeyValue{attribute.String("wfseye.view_store", opt.ViewStoreType)}
+}
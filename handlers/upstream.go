@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errCircuitOpen = errors.New("Upstream WFS server is unavailable (circuit breaker is open). Try again later")
+
+//UpstreamOptions configures how wfs-eye calls an upstream WFS server
+type UpstreamOptions struct {
+	ConnectTimeout time.Duration
+	//ReadTimeout is the max time waiting for the response headers or for each read of the body
+	ReadTimeout  time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	MaxIdleConns int
+	//MaxConns limits connections to the upstream host. 0 means no limit
+	MaxConns int
+	//BreakerFailures is the number of consecutive failed calls that opens the circuit breaker. 0 disables it
+	BreakerFailures int
+	BreakerOpenTime time.Duration
+}
+
+//upstreamClient calls an upstream WFS server retrying failed GETs and
+//failing fast while the server is known to be down
+type upstreamClient struct {
+	name         string
+	url          string
+	headers      map[string]string
+	auth         upstreamAuth
+	client       *http.Client
+	retries      int
+	retryBackoff time.Duration
+	breaker      *circuitBreaker
+}
+
+func newUpstreamClient(name string, url string, headers map[string]string, auth upstreamAuth, uopt UpstreamOptions) *upstreamClient {
+	dialer := &net.Dialer{
+		Timeout:   uopt.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &readTimeoutConn{Conn: conn, readTimeout: uopt.ReadTimeout}, nil
+		},
+		MaxIdleConns:          uopt.MaxIdleConns,
+		MaxIdleConnsPerHost:   uopt.MaxIdleConns,
+		MaxConnsPerHost:       uopt.MaxConns,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   uopt.ConnectTimeout,
+		ResponseHeaderTimeout: uopt.ReadTimeout,
+	}
+	return &upstreamClient{
+		name:    name,
+		url:     url,
+		headers: headers,
+		auth:    auth,
+		//no overall timeout so that big responses can be streamed. hung connections are detected by the read timeout
+		client:       &http.Client{Transport: transport},
+		retries:      uopt.Retries,
+		retryBackoff: uopt.RetryBackoff,
+		breaker:      &circuitBreaker{name: name, maxFailures: uopt.BreakerFailures, openTime: uopt.BreakerOpenTime},
+	}
+}
+
+//Get performs a GET, retrying on network errors and 5xx responses with exponential backoff.
+//The last response is returned even if it is a 5xx. The trace of ctx is propagated to upstream
+func (u *upstreamClient) Get(ctx context.Context, url string) (resp *http.Response, err error) {
+	ctx, span := tracer.Start(ctx, "upstream GET", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("wfseye.upstream", u.name), semconv.HTTPURLKey.String(url)))
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+			span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+		}
+		endSpan(span, err)
+	}()
+
+	if !u.breaker.Allow() {
+		upstreamErrors.WithLabelValues(u.name, "circuit_open").Inc()
+		return nil, errCircuitOpen
+	}
+	start := time.Now()
+
+	for attempt := 0; attempt <= u.retries; attempt++ {
+		if attempt > 0 {
+			backoff := u.retryBackoff * time.Duration(1<<uint(attempt-1))
+			logrus.Debugf("Retrying WFS request in %s. attempt=%d", backoff, attempt)
+			span.AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt)))
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				//the client gave up while waiting to retry
+				logrus.Debugf("WFS request canceled during retry backoff. url=%s", url)
+				u.breaker.Cancel()
+				return nil, ctx.Err()
+			}
+		}
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			//upstream wasn't called. Release the breaker trial if this was it
+			u.breaker.Cancel()
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		for k, v := range u.headers {
+			req.Header.Set(k, v)
+		}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+		if u.auth != nil {
+			err = u.auth.apply(req)
+			if err != nil {
+				u.breaker.Cancel()
+				return nil, err
+			}
+		}
+		resp, err = u.client.Do(req)
+		if err != nil && ctx.Err() != nil {
+			//the client went away or timed out. It isn't an upstream failure, so it is not retried nor counted
+			logrus.Debugf("WFS request canceled. url=%s. err=%s", url, err)
+			u.breaker.Cancel()
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logrus.Debugf("WFS request failed. url=%s. err=%s", url, err)
+			continue
+		}
+		if resp.StatusCode < 500 || attempt == u.retries {
+			break
+		}
+		logrus.Debugf("WFS request failed. url=%s. status=%d", url, resp.StatusCode)
+		//drain so that the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if err != nil {
+		upstreamErrors.WithLabelValues(u.name, "network").Inc()
+		upstreamDuration.WithLabelValues(u.name, "error").Observe(time.Since(start).Seconds())
+	} else {
+		if resp.StatusCode >= 500 {
+			upstreamErrors.WithLabelValues(u.name, "status").Inc()
+		}
+		upstreamDuration.WithLabelValues(u.name, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	}
+
+	if err != nil || resp.StatusCode >= 500 {
+		u.breaker.Failure()
+	} else {
+		u.breaker.Success()
+	}
+	return resp, err
+}
+
+//readTimeoutConn fails reads that take longer than readTimeout
+//without limiting the total time of a response
+type readTimeoutConn struct {
+	net.Conn
+	readTimeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return c.Conn.Read(b)
+}
+
+//circuitBreaker opens after maxFailures consecutive failures. While open, calls are refused
+//until openTime has passed. Then a single trial call is allowed to check if upstream is back
+type circuitBreaker struct {
+	name        string
+	mutex       sync.Mutex
+	maxFailures int
+	openTime    time.Duration
+	failures    int
+	openUntil   time.Time
+	trial       bool
+}
+
+func (b *circuitBreaker) Allow() bool {
+	if b.maxFailures <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < b.maxFailures {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+func (b *circuitBreaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.maxFailures > 0 && b.failures >= b.maxFailures {
+		logrus.Infof("Upstream WFS %s is back. Closing circuit breaker", b.name)
+	}
+	b.failures = 0
+	b.trial = false
+}
+
+//Cancel is called instead of Success or Failure when upstream wasn't reached, like when the call was
+//abandoned by the caller or the request couldn't be authenticated, so that another trial call can be made if this was the trial
+func (b *circuitBreaker) Cancel() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.trial = false
+}
+
+func (b *circuitBreaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	b.trial = false
+	if b.maxFailures > 0 && b.failures >= b.maxFailures {
+		b.openUntil = time.Now().Add(b.openTime)
+		logrus.Warnf("Upstream WFS %s failed %d times in a row. Opening circuit breaker for %s", b.name, b.failures, b.openTime)
+	}
+}
+
+//errorStatus is the HTTP status reported to clients for errors from upstream calls and view resolution
+func errorStatus(err error) int {
+	if err == errCircuitOpen {
+		return http.StatusServiceUnavailable
+	}
+	if err == ErrViewNotFound || err == errCollectionNotFound || err == errFeatureNotFound {
+		return http.StatusNotFound
+	}
+	if _, ok := err.(*hiddenPropertyError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+//upstreamError wraps errors from upstreamClient.Get keeping errCircuitOpen as is
+func upstreamError(err error) error {
+	if err == errCircuitOpen {
+		return err
+	}
+	return fmt.Errorf("Error requesting WFS service. err=%s", err)
+}
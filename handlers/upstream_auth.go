@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+//upstreamAuthConfig configures credentials sent to an upstream. Values may reference
+//environment variables as '${VAR}' so that secrets don't need to be stored in the upstreams file
+type upstreamAuthConfig struct {
+	//Type is 'header', 'basic' or 'oauth2'
+	Type string `json:"type"`
+
+	//header
+	Header string `json:"header,omitempty"`
+	Value  string `json:"value,omitempty"`
+
+	//basic
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	//oauth2 client credentials
+	TokenURL     string   `json:"tokenUrl,omitempty"`
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"`
+	Scopes       []string `json:"scopes,omitempty"`
+}
+
+//upstreamAuth adds credentials to requests sent to an upstream
+type upstreamAuth interface {
+	apply(req *http.Request) error
+}
+
+type headerAuth struct {
+	header string
+	value  string
+}
+
+func (a *headerAuth) apply(req *http.Request) error {
+	req.Header.Set(a.header, a.value)
+	return nil
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) apply(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+//oauth2Auth gets tokens with the client credentials grant. Tokens are cached
+//and a new one is requested shortly before the current one expires
+type oauth2Auth struct {
+	tokens oauth2.TokenSource
+}
+
+func (a *oauth2Auth) apply(req *http.Request) error {
+	token, err := a.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("Error getting OAuth2 token. err=%s", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+func newUpstreamAuth(ac *upstreamAuthConfig, uopt UpstreamOptions) (upstreamAuth, error) {
+	if ac == nil {
+		return nil, nil
+	}
+	switch ac.Type {
+	case "header":
+		if ac.Header == "" || ac.Value == "" {
+			return nil, fmt.Errorf("'header' and 'value' are required for 'header' auth")
+		}
+		return &headerAuth{header: ac.Header, value: os.ExpandEnv(ac.Value)}, nil
+	case "basic":
+		if ac.Username == "" {
+			return nil, fmt.Errorf("'username' is required for 'basic' auth")
+		}
+		return &basicAuth{username: os.ExpandEnv(ac.Username), password: os.ExpandEnv(ac.Password)}, nil
+	case "oauth2":
+		if ac.TokenURL == "" || ac.ClientID == "" {
+			return nil, fmt.Errorf("'tokenUrl' and 'clientId' are required for 'oauth2' auth")
+		}
+		cc := &clientcredentials.Config{
+			TokenURL:     ac.TokenURL,
+			ClientID:     os.ExpandEnv(ac.ClientID),
+			ClientSecret: os.ExpandEnv(ac.ClientSecret),
+			Scopes:       ac.Scopes,
+		}
+		//token requests must not hang forever either
+		hc := &http.Client{Timeout: uopt.ConnectTimeout + uopt.ReadTimeout}
+		if hc.Timeout == 0 {
+			hc.Timeout = 30 * time.Second
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, hc)
+		return &oauth2Auth{tokens: cc.TokenSource(ctx)}, nil
+	default:
+		return nil, fmt.Errorf("Unknown auth type '%s'. Use 'header', 'basic' or 'oauth2'", ac.Type)
+	}
+}
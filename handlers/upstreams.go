@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	//defaultUpstream is the WFS server defined by 'wfs-url'
+	defaultUpstream *upstreamClient
+	//namedUpstreams are referenced by collections as 'upstream:collection'
+	namedUpstreams map[string]*upstreamClient
+)
+
+//upstreamConfig is an entry of the upstreams file. Unset options use the global upstream flags
+type upstreamConfig struct {
+	Name            string              `json:"name"`
+	URL             string              `json:"url"`
+	Headers         map[string]string   `json:"headers,omitempty"`
+	Auth            *upstreamAuthConfig `json:"auth,omitempty"`
+	ConnectTimeout  string              `json:"connectTimeout,omitempty"`
+	ReadTimeout     string              `json:"readTimeout,omitempty"`
+	Retries         *int                `json:"retries,omitempty"`
+	RetryBackoff    string              `json:"retryBackoff,omitempty"`
+	MaxIdleConns    *int                `json:"maxIdleConns,omitempty"`
+	MaxConns        *int                `json:"maxConns,omitempty"`
+	BreakerFailures *int                `json:"breakerFailures,omitempty"`
+	BreakerOpenTime string              `json:"breakerOpenTime,omitempty"`
+}
+
+func setupUpstreams(opt Options) error {
+	defaultUpstream = newUpstreamClient("default", opt.WFSURL, nil, nil, opt.Upstream)
+	namedUpstreams = make(map[string]*upstreamClient)
+	if opt.UpstreamsFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(opt.UpstreamsFile)
+	if err != nil {
+		return fmt.Errorf("Error reading upstreams file %s. err=%s", opt.UpstreamsFile, err)
+	}
+	configs := make([]upstreamConfig, 0)
+	err = json.Unmarshal(data, &configs)
+	if err != nil {
+		return fmt.Errorf("Error parsing upstreams file %s. err=%s", opt.UpstreamsFile, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, uc := range configs {
+		if uc.Name == "" || strings.Contains(uc.Name, ":") {
+			return fmt.Errorf("Invalid upstream name '%s'. It is required and cannot contain ':'", uc.Name)
+		}
+		//the 'default' entry configures the upstream defined by 'wfs-url'
+		if uc.Name == "default" && uc.URL == "" {
+			uc.URL = opt.WFSURL
+		}
+		if uc.URL == "" {
+			return fmt.Errorf("'url' is required for upstream %s", uc.Name)
+		}
+		if seen[uc.Name] {
+			return fmt.Errorf("Duplicate upstream name %s", uc.Name)
+		}
+		seen[uc.Name] = true
+		uopt, err := uc.options(opt.Upstream)
+		if err != nil {
+			return fmt.Errorf("Invalid options for upstream %s. err=%s", uc.Name, err)
+		}
+		auth, err := newUpstreamAuth(uc.Auth, uopt)
+		if err != nil {
+			return fmt.Errorf("Invalid auth for upstream %s. err=%s", uc.Name, err)
+		}
+		u := newUpstreamClient(uc.Name, strings.TrimRight(uc.URL, "/"), uc.Headers, auth, uopt)
+		if uc.Name == "default" {
+			defaultUpstream = u
+		} else {
+			namedUpstreams[uc.Name] = u
+		}
+		logrus.Infof("Upstream %s is %s", uc.Name, uc.URL)
+	}
+	return nil
+}
+
+func (uc upstreamConfig) options(defaults UpstreamOptions) (UpstreamOptions, error) {
+	uopt := defaults
+	durations := []struct {
+		value string
+		field *time.Duration
+	}{
+		{uc.ConnectTimeout, &uopt.ConnectTimeout},
+		{uc.ReadTimeout, &uopt.ReadTimeout},
+		{uc.RetryBackoff, &uopt.RetryBackoff},
+		{uc.BreakerOpenTime, &uopt.BreakerOpenTime},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return uopt, err
+		}
+		*d.field = v
+	}
+	if uc.Retries != nil {
+		uopt.Retries = *uc.Retries
+	}
+	if uc.MaxIdleConns != nil {
+		uopt.MaxIdleConns = *uc.MaxIdleConns
+	}
+	if uc.MaxConns != nil {
+		uopt.MaxConns = *uc.MaxConns
+	}
+	if uc.BreakerFailures != nil {
+		uopt.BreakerFailures = *uc.BreakerFailures
+	}
+	return uopt, nil
+}
+
+//upstreamFor returns the upstream of a collection referenced as 'upstream:collection' and the
+//collection name in that upstream. Names without a known upstream prefix, like 'topp:states'
+//in GeoServer, are collections of the default upstream
+func upstreamFor(collection string) (*upstreamClient, string) {
+	parts := strings.SplitN(collection, ":", 2)
+	if len(parts) == 2 {
+		u, ok := namedUpstreams[parts[0]]
+		if ok {
+			return u, parts[1]
+		}
+	}
+	return defaultUpstream, collection
+}
+
+//upstreamList returns the default upstream followed by the named ones sorted by name
+func upstreamList() []*upstreamClient {
+	names := make([]string, 0)
+	for name := range namedUpstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	us := []*upstreamClient{defaultUpstream}
+	for _, name := range names {
+		us = append(us, namedUpstreams[name])
+	}
+	return us
+}
+
+//collectionRef is how wfs-eye clients refer to a collection of upstream u
+func collectionRef(u *upstreamClient, collection string) string {
+	if u == defaultUpstream {
+		return collection
+	}
+	return fmt.Sprintf("%s:%s", u.name, collection)
+}
+
+//fetchAllUpstreamCollections returns the collection documents of all upstreams with 'id' set to
+//the name clients use to reference them. Upstreams that fail are skipped
+func fetchAllUpstreamCollections(ctx context.Context) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0)
+	for _, u := range upstreamList() {
+		cs, err := fetchUpstreamCollections(ctx, u)
+		if err != nil {
+			logrus.Warnf("Couldn't get collections of upstream %s. err=%s", u.name, err)
+			continue
+		}
+		for _, c := range cs {
+			id := upstreamCollectionID(c)
+			if id == "" {
+				continue
+			}
+			c["id"] = collectionRef(u, id)
+			result = append(result, c)
+		}
+	}
+	return result
+}
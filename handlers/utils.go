@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/paulmach/orb/geojson"
 	"github.com/paulsmith/gogeos/geos"
 )
 
@@ -112,6 +116,9 @@ func intersectionBBoxStr(bboxstr1 string, bbox2 []float64) (bbox string, err err
 
 func bboxFromString(bboxstr string) ([]float64, error) {
 	bbstr := strings.Split(bboxstr, ",")
+	if len(bbstr) != 4 {
+		return []float64{}, fmt.Errorf("Bounding box must have 4 numbers")
+	}
 	a, erra := strconv.ParseFloat(bbstr[0], 64)
 	b, errb := strconv.ParseFloat(bbstr[1], 64)
 	c, errc := strconv.ParseFloat(bbstr[2], 64)
@@ -125,3 +132,61 @@ func bboxFromString(bboxstr string) ([]float64, error) {
 func validBBox(bbox []float64) bool {
 	return (bbox[0] < bbox[2]) && (bbox[3] < bbox[1])
 }
+
+//baseURL returns the URL clients used to reach wfs-eye, honoring reverse proxy headers
+func baseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	proto := c.GetHeader("X-Forwarded-Proto")
+	if proto != "" {
+		scheme = proto
+	}
+	host := c.Request.Host
+	fhost := c.GetHeader("X-Forwarded-Host")
+	if fhost != "" {
+		host = fhost
+	}
+	return fmt.Sprintf("%s://%s", scheme, host)
+}
+
+//featureTime returns the feature instant from property. WFS 3.0 servers like wfsgis
+//store the timestamp used for 'time' queries in the 'time' property
+func featureTime(f *geojson.Feature, property string) *time.Time {
+	ts, ok := f.Properties[property].(string)
+	if !ok || ts == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, completeDate(ts, true))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+//propertyString returns a feature property value as text, the way it is compared
+//with filter attributes in WFS queries
+func propertyString(v interface{}) (string, bool) {
+	switch pv := v.(type) {
+	case string:
+		return pv, true
+	case float64:
+		return strconv.FormatFloat(pv, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(pv), true
+	}
+	return "", false
+}
+
+//marshalJSON is json.Marshal without escaping '&', '<' and '>', which are common in URLs
+func marshalJSON(v interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
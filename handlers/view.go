@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,37 +11,54 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"gopkg.in/mgo.v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	opt               Options
-	viewCache         map[string]View
-	viewNotFoundCache map[string]bool
+	viewCache         *lruCache
+	viewNotFoundCache *lruCache
 )
 
 type View struct {
-	Name              *string            `json:"name,omitempty" bson:"name,omitempty"`
-	Collection        string             `json:"collection,omitempty" bson:"collection,omitempty"`
-	DefaultTime       *string            `json:"defaultTime,omitempty" bson:"defaultTime,omitempty"`
-	MaxTimeRange      *string            `json:"maxTimeRange,omitempty" bson:"maxTimeRange,omitempty"`
-	DefaultLimit      *int               `json:"defaultLimit,omitempty" bson:"defaultLimit,omitempty"`
-	MaxLimit          *int               `json:"maxLimit,omitempty" bson:"maxLimit,omitempty"`
-	DefaultBBox       *[]float64         `json:"defaultBbox,omitempty" bson:"defaultBbox,omitempty"`
-	MaxBBox           *[]float64         `json:"maxBbox,omitempty" bson:"maxBbox,omitempty"`
-	DefaultFilterAttr *map[string]string `json:"defaultFilterAttr,omitempty" bson:"defaultFilterAttr,omitempty"`
-	LastUpdate        time.Time          `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
+	Name                *string            `json:"name,omitempty" bson:"name,omitempty"`
+	Collection          string             `json:"collection,omitempty" bson:"collection,omitempty"`
+	DefaultTime         *string            `json:"defaultTime,omitempty" bson:"defaultTime,omitempty"`
+	MaxTimeRange        *string            `json:"maxTimeRange,omitempty" bson:"maxTimeRange,omitempty"`
+	DefaultLimit        *int               `json:"defaultLimit,omitempty" bson:"defaultLimit,omitempty"`
+	MaxLimit            *int               `json:"maxLimit,omitempty" bson:"maxLimit,omitempty"`
+	DefaultBBox         *[]float64         `json:"defaultBbox,omitempty" bson:"defaultBbox,omitempty"`
+	MaxBBox             *[]float64         `json:"maxBbox,omitempty" bson:"maxBbox,omitempty"`
+	MaxGeometry         *ViewGeometry      `json:"maxGeometry,omitempty" bson:"maxGeometry,omitempty"`
+	ClipGeometries      *bool              `json:"clipGeometries,omitempty" bson:"clipGeometries,omitempty"`
+	CRS                 *[]string          `json:"crs,omitempty" bson:"crs,omitempty"`
+	SimplifyTolerance   *float64           `json:"simplifyTolerance,omitempty" bson:"simplifyTolerance,omitempty"`
+	SimplifyMethod      *string            `json:"simplifyMethod,omitempty" bson:"simplifyMethod,omitempty"`
+	CoordinatePrecision *int               `json:"coordinatePrecision,omitempty" bson:"coordinatePrecision,omitempty"`
+	DefaultFilterAttr   *map[string]string `json:"defaultFilterAttr,omitempty" bson:"defaultFilterAttr,omitempty"`
+	IncludeProperties   *[]string          `json:"includeProperties,omitempty" bson:"includeProperties,omitempty"`
+	ExcludeProperties   *[]string          `json:"excludeProperties,omitempty" bson:"excludeProperties,omitempty"`
+	RenameProperties    *map[string]string `json:"renameProperties,omitempty" bson:"renameProperties,omitempty"`
+	Access              *ViewAccess        `json:"access,omitempty" bson:"access,omitempty"`
+	RateLimit           *ViewRateLimit     `json:"rateLimit,omitempty" bson:"rateLimit,omitempty"`
+	CacheTTL            *string            `json:"cacheTTL,omitempty" bson:"cacheTTL,omitempty"`
+	TimeProperty        *string            `json:"timeProperty,omitempty" bson:"timeProperty,omitempty"`
+	LastUpdate          time.Time          `json:"lastUpdate,omitempty" bson:"lastUpdate,omitempty"`
 }
 
 func (h *HTTPServer) setupViewHandlers(opt0 Options) {
 	opt = opt0
-	h.router.POST("/views", createView())
-	h.router.PUT("/views/:vname", updateView())
-	h.router.GET("/views", listViews())
-	h.router.GET("/views/:vname", getView())
-	h.router.DELETE("/views/:vname", deleteView())
-	viewCache = make(map[string]View)
-	viewNotFoundCache = make(map[string]bool)
+	h.router.POST("/views", requireViewsRole(roleWrite), createView())
+	h.router.PUT("/views/:vname", requireViewsRole(roleWrite), updateView())
+	h.router.GET("/views", requireViewsRole(roleRead), listViews())
+	h.router.GET("/views/:vname", requireViewsRole(roleRead), getView())
+	h.router.DELETE("/views/:vname", requireViewsRole(roleWrite), deleteView())
+	viewCache = newLRUCache(opt.ViewCacheSize, opt.ViewCacheTTL)
+	viewNotFoundCache = newLRUCache(opt.ViewNotFoundCacheSize, opt.ViewNotFoundCacheTTL)
+	if opt.ViewWatchInterval > 0 {
+		go watchViewStore(opt.ViewStore, opt.ViewWatchInterval)
+	}
 }
 
 func createView() func(*gin.Context) {
@@ -68,63 +86,28 @@ func createView() func(*gin.Context) {
 			return
 		}
 
-		//VALIDATE DATES
-		if view.MaxTimeRange != nil {
-			a, b, err := getDateStartEndFromString(*view.MaxTimeRange)
-			if err != nil || (a == nil && b == nil) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'maxTimeRange' date range. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'"})
-				return
-			}
-		}
-		if view.DefaultTime != nil {
-			a, b, err := getDateStartEndFromString(*view.DefaultTime)
-			if err != nil || (a == nil && b == nil) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'time' date. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'"})
-				return
-			}
-		}
-
-		//VALIDATE BBOX
-		if *view.DefaultBBox != nil {
-			if !validBBox(*view.DefaultBBox) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'defaultBbox'. It must be in (north,west,east,south) order"})
-				return
-			}
-		}
-		if *view.MaxBBox != nil {
-			if !validBBox(*view.MaxBBox) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'maxBbox'. It must be in (north,west,east,south) order"})
-				return
-			}
+		err = validateView(view)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
 		}
+		//API keys are never stored in plain text
+		view = withHashedAPIKeys(view)
 
 		view.LastUpdate = time.Now()
 
-		sc := opt.MongoSession.Copy()
-		defer sc.Close()
-		st := sc.DB(opt.MongoDBName).C("views")
-
-		//check duplicate
-		count, err1 := st.Find(bson.M{"name": view.Name}).Count()
-		if err1 != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "Error checking for existing view name"})
-			logrus.Errorf("Error checking for existing view name. err=%s", err1)
-			return
-		}
-		if count > 0 {
+		logrus.Debugf("Creating view %s", *view.Name)
+		err = opt.ViewStore.Create(view)
+		if err == ErrViewExists {
 			c.JSON(http.StatusBadRequest, gin.H{"message": "Duplicate view name"})
 			return
 		}
-
-		logrus.Debugf("Creating view %s", *view.Name)
-		err0 := st.Insert(view)
-		if err0 != nil {
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, "Error storing view")
-			logrus.Errorf("Error storing view to Mongo. err=%s", err0)
+			logrus.Errorf("Error storing view. err=%s", err)
 			return
 		}
-		delete(viewCache, *view.Name)
-		delete(viewNotFoundCache, *view.Name)
+		invalidateView(*view.Name)
 		c.JSON(http.StatusCreated, gin.H{"message": "View created successfuly"})
 	}
 }
@@ -147,10 +130,6 @@ func updateView() func(*gin.Context) {
 			return
 		}
 
-		sc := opt.MongoSession.Copy()
-		defer sc.Close()
-		st := sc.DB(opt.MongoDBName).C("views")
-
 		view.Name = nil
 
 		if name == view.Collection {
@@ -158,74 +137,117 @@ func updateView() func(*gin.Context) {
 			return
 		}
 
-		//VALIDATE DATES
-		if view.MaxTimeRange != nil {
-			a, b, err := getDateStartEndFromString(*view.MaxTimeRange)
-			if err != nil || (a == nil && b == nil) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'maxTimeRange' date range. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'"})
-				return
-			}
-		}
-		if view.DefaultTime != nil {
-			a, b, err := getDateStartEndFromString(*view.DefaultTime)
-			if err != nil || (a == nil && b == nil) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'time' date. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'"})
-				return
-			}
-		}
-
-		//VALIDATE BBOX
-		if *view.DefaultBBox != nil {
-			if !validBBox(*view.DefaultBBox) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'defaultBbox'. It must be in (north,west,east,south) order"})
-				return
-			}
-		}
-		if *view.MaxBBox != nil {
-			if !validBBox(*view.MaxBBox) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid 'maxBbox'. It must be in (north,west,east,south) order"})
-				return
-			}
-		}
-
-		//CHECK IF VIEW EXISTS
-		count, err1 := st.Find(bson.M{"name": name}).Count()
-		if err1 != nil {
-			c.JSON(http.StatusBadRequest, fmt.Sprintf("Error updating view. err=%s", err.Error()))
-			return
-		}
-		if count == 0 {
-			c.JSON(http.StatusNotFound, fmt.Sprintf("Couldn't find view %s", name))
+		err = validateView(view)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 			return
 		}
+		//API keys are never stored in plain text
+		view = withHashedAPIKeys(view)
 
 		view.LastUpdate = time.Now()
 
 		logrus.Debugf("Updating view with %v", view)
-		err = st.Update(bson.M{"name": name}, bson.M{"$set": view})
+		err = opt.ViewStore.Update(name, view)
+		if err == ErrViewNotFound {
+			c.JSON(http.StatusNotFound, fmt.Sprintf("Couldn't find view %s", name))
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, "Error updating view")
 			logrus.Errorf("Error updating view %s. err=%s", name, err)
 			return
 		}
-		delete(viewCache, name)
-		delete(viewNotFoundCache, name)
+		invalidateView(name)
 		c.JSON(http.StatusOK, gin.H{"message": "View updated successfully"})
 	}
 }
 
+//validateView checks the fields of a view sent to createView or updateView
+func validateView(view View) error {
+	//VALIDATE DATES
+	if view.MaxTimeRange != nil {
+		a, b, err := getDateStartEndFromString(*view.MaxTimeRange)
+		if err != nil || (a == nil && b == nil) {
+			return fmt.Errorf("Invalid 'maxTimeRange' date range. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'")
+		}
+	}
+	if view.DefaultTime != nil {
+		a, b, err := getDateStartEndFromString(*view.DefaultTime)
+		if err != nil || (a == nil && b == nil) {
+			return fmt.Errorf("Invalid 'time' date. It must be something like '2019-01-01/2020-06-30', '2019-01-01/' or '/2020-06-30'")
+		}
+	}
+
+	//VALIDATE BBOX
+	if view.DefaultBBox != nil {
+		if !validBBox(*view.DefaultBBox) {
+			return fmt.Errorf("Invalid 'defaultBbox'. It must be in (north,west,east,south) order")
+		}
+	}
+	if view.MaxBBox != nil {
+		if !validBBox(*view.MaxBBox) {
+			return fmt.Errorf("Invalid 'maxBbox'. It must be in (north,west,east,south) order")
+		}
+	}
+	if view.MaxGeometry != nil {
+		err := view.MaxGeometry.validate()
+		if err != nil {
+			return fmt.Errorf("Invalid 'maxGeometry'. err=%s", err)
+		}
+	}
+	if view.ClipGeometries != nil && *view.ClipGeometries && view.MaxBBox == nil && view.MaxGeometry == nil {
+		return fmt.Errorf("'clipGeometries' requires 'maxBbox' or 'maxGeometry'")
+	}
+	if view.CRS != nil {
+		err := validateViewCRS(*view.CRS)
+		if err != nil {
+			return fmt.Errorf("Invalid 'crs'. err=%s", err)
+		}
+	}
+	err := validateGeneralization(view)
+	if err != nil {
+		return fmt.Errorf("Invalid view. err=%s", err)
+	}
+	err = validatePropertyProjection(view)
+	if err != nil {
+		return fmt.Errorf("Invalid view. err=%s", err)
+	}
+
+	//VALIDATE ACCESS
+	if view.Access != nil {
+		err := view.Access.validate()
+		if err != nil {
+			return fmt.Errorf("Invalid 'access'. err=%s", err)
+		}
+	}
+
+	if view.RateLimit != nil {
+		err := view.RateLimit.validate()
+		if err != nil {
+			return fmt.Errorf("Invalid 'rateLimit'. err=%s", err)
+		}
+	}
+
+	if view.CacheTTL != nil {
+		ttl, err := time.ParseDuration(*view.CacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("Invalid 'cacheTTL'. It must be a duration like '30s' or '5m'. '0s' disables caching")
+		}
+	}
+	return nil
+}
+
 func listViews() func(*gin.Context) {
 	return func(c *gin.Context) {
-		sc := opt.MongoSession.Copy()
-		defer sc.Close()
-		st := sc.DB(opt.MongoDBName).C("views")
-
-		views := make([]View, 0)
-		err := st.Find(nil).All(&views)
+		views, err := opt.ViewStore.List()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error listing schedules. err=%s", err.Error()))
+			c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error listing views. err=%s", err.Error()))
 			return
 		}
+		for i := range views {
+			views[i] = withHashedAPIKeys(views[i])
+		}
 		c.JSON(http.StatusOK, views)
 	}
 }
@@ -235,66 +257,80 @@ func getView() func(*gin.Context) {
 		logrus.Debugf("getView")
 		name := c.Param("vname")
 
-		sc := opt.MongoSession.Copy()
-		defer sc.Close()
-		st := sc.DB(opt.MongoDBName).C("views")
-
-		var view View
-		err := st.Find(bson.M{"name": name}).One(&view)
+		view, err := opt.ViewStore.Get(name)
+		if err == ErrViewNotFound {
+			c.JSON(http.StatusNotFound, fmt.Sprintf("Couldn't find view %s", name))
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error getting view. err=%s", err.Error()))
 			return
 		}
 
-		c.JSON(http.StatusOK, view)
+		c.JSON(http.StatusOK, withHashedAPIKeys(view))
 	}
 }
 
-func findView(name string) (View, error) {
-	sc := opt.MongoSession.Copy()
-	defer sc.Close()
-	st := sc.DB(opt.MongoDBName).C("views")
+//viewTimeProperty is the feature property checked against 'maxTimeRange' when a single feature is requested
+func viewTimeProperty(view View) string {
+	if view.TimeProperty != nil && *view.TimeProperty != "" {
+		return *view.TimeProperty
+	}
+	return "time"
+}
 
+func findView(ctx context.Context, name string) (View, error) {
 	//get view from cache
-	view, ok := viewCache[name]
+	v, ok := viewCache.Get(name)
 	if ok {
-		return view, nil
+		return v.(View), nil
 	}
 
 	//get view not found in cache (do not query for known not found views)
-	_, notFound := viewNotFoundCache[name]
+	_, notFound := viewNotFoundCache.Get(name)
 	if notFound {
-		return View{}, fmt.Errorf("View not found")
+		return View{}, ErrViewNotFound
 	}
 
-	//not found in cache. fetch from Mongo
-	err := st.Find(bson.M{"name": name}).One(&view)
+	//not found in cache. fetch from store
+	_, span := tracer.Start(ctx, "view store get", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(viewStoreAttributes(), attribute.String("wfseye.view", name))...))
+	view, err := opt.ViewStore.Get(name)
+	if err == ErrViewNotFound {
+		span.SetAttributes(attribute.Bool("wfseye.view_found", false))
+		span.End()
+		viewNotFoundCache.Set(name, true)
+		return View{}, err
+	}
+	endSpan(span, err)
 	if err != nil {
-		//warning: this cache has a potential risk of memory leak in case of hugh amounts of
-		//queries for views that are not found. limit cache size later
-		viewNotFoundCache[name] = true
-		return View{}, fmt.Errorf("View not found")
+		return View{}, fmt.Errorf("Error fetching view %s. err=%s", name, err)
 	}
-	viewCache[name] = view
+	viewCache.Set(name, view)
 	return view, nil
 }
 
+func invalidateView(name string) {
+	viewCache.Delete(name)
+	viewNotFoundCache.Delete(name)
+	invalidateResponses(name)
+}
+
 func deleteView() func(*gin.Context) {
 	return func(c *gin.Context) {
 		logrus.Debugf("deleteView")
 		name := c.Param("vname")
 
-		sc := opt.MongoSession.Copy()
-		defer sc.Close()
-		st := sc.DB(opt.MongoDBName).C("views")
-
-		err := st.Remove(bson.M{"name": name})
+		err := opt.ViewStore.Delete(name)
+		if err == ErrViewNotFound {
+			c.JSON(http.StatusNotFound, fmt.Sprintf("Couldn't find view %s", name))
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, fmt.Sprintf("Error deleting view. err=%s", err.Error()))
 			return
 		}
-		delete(viewCache, name)
-		delete(viewNotFoundCache, name)
+		invalidateView(name)
 		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Deleted view successfully. name=%s", name)})
 	}
 }
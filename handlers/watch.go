@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//watchViewStore polls the view store and drops cached entries of views that were created, updated
+//or deleted since the last poll, so that changes made through other replicas are seen here
+//within one interval
+func watchViewStore(store ViewStore, interval time.Duration) {
+	logrus.Infof("Watching view store for changes every %s", interval)
+	known, err := viewVersions(store)
+	if err != nil {
+		logrus.Warnf("Couldn't list views for watching changes. err=%s", err)
+	}
+	for range time.Tick(interval) {
+		current, err := viewVersions(store)
+		if err != nil {
+			logrus.Warnf("Couldn't list views for watching changes. err=%s", err)
+			continue
+		}
+		for name, lastUpdate := range current {
+			previous, ok := known[name]
+			if !ok || !previous.Equal(lastUpdate) {
+				logrus.Debugf("View %s changed. Invalidating cache", name)
+				invalidateView(name)
+			}
+		}
+		for name := range known {
+			_, ok := current[name]
+			if !ok {
+				logrus.Debugf("View %s was removed. Invalidating cache", name)
+				invalidateView(name)
+			}
+		}
+		known = current
+	}
+}
+
+func viewVersions(store ViewStore) (map[string]time.Time, error) {
+	views, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]time.Time)
+	for _, v := range views {
+		if v.Name == nil {
+			continue
+		}
+		versions[*v.Name] = v.LastUpdate
+	}
+	return versions, nil
+}
@@ -1,43 +1,63 @@
 package handlers
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 )
 
+var errFeatureNotFound = errors.New("Feature not found")
+
 func (h *HTTPServer) setupWFSHandlers(opt Options) {
-	h.router.GET("/collections/:collection/items", getFeatures(opt))
+	h.router.GET("/collections", instrument("collections", listCollections(opt)))
+	h.router.GET("/collections/:collection", instrument("collection", getCollection(opt)))
+	h.router.GET("/collections/:collection/items", instrument("items", getFeatures(opt)))
+	h.router.GET("/collections/:collection/items/:featureId", instrument("item", getFeature(opt)))
+	h.router.GET("/collections/:collection/explain", requireViewsRole(roleRead), instrument("explain", explainQuery()))
+	responseCache = newLRUCache(opt.ResponseCacheSize, opt.ResponseCacheTTL)
 }
 
 func getFeatures(opt Options) func(*gin.Context) {
 	return func(c *gin.Context) {
 		collection := c.Param("collection")
+		if !authorizeCollection(c, collection) {
+			return
+		}
+		limits := limitsFor(c, collection)
+		if !checkRateLimit(c, limits) {
+			return
+		}
 
-		bboxstr := c.Query("bbox")
-		if bboxstr != "" {
-			bboxstr = fmt.Sprintf("%s", bboxstr)
+		bboxstr, ok := requestBBox(c, collection)
+		if !ok {
+			return
 		}
-		if bboxstr != "" {
-			bb, err := bboxFromString(bboxstr)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid 'bbox'. err=%s", err)})
-				return
-			}
-			if !validBBox(bb) {
-				c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid 'bbox'. It must be in order (west,north,east,south). err=%s", err)})
-				return
-			}
+		crs, ok := requestCRS(c, collection, "crs")
+		if !ok {
+			return
+		}
+		zoom, err := parseZoom(c.Query("zoom"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid 'zoom'. err=%s", err)})
+			return
 		}
 
 		limitstr := c.Query("limit")
@@ -50,35 +70,305 @@ func getFeatures(opt Options) func(*gin.Context) {
 			timestr = fmt.Sprintf("%s", timestr)
 		}
 
-		propertiesFilterStr := ""
-		params := c.Request.URL.Query()
-		for k, v := range params {
-			if k != "time" && k != "bbox" && k != "limit" {
-				propertiesFilterStr = fmt.Sprintf("%s&%s=%s", propertiesFilterStr, k, v)
-			}
+		pagingstr, propertiesFilterStr := splitQueryParams(c.Request.URL.Query())
+
+		c.Header("Content-Crs", crs.contentCRS())
+		if notModified(c, collection, bboxstr, limitstr, timestr, pagingstr, propertiesFilterStr) {
+			return
 		}
 
 		pc := make([]string, 0)
-		fc, err := resolveFeatureCollection(collection, bboxstr, limitstr, timestr, propertiesFilterStr, pc)
+		fs, err := resolveFeatureCollection(c.Request.Context(), collection, bboxstr, limitstr, timestr, pagingstr, propertiesFilterStr, pc, nil)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error getting collection features. err=%s", err)})
+			c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error getting collection features. err=%s", err)})
 			logrus.Warnf("Error getting collection features. err=%s", err)
 			return
 		}
+		defer fs.Close()
+		fs.crs = crs
+		fs.generalization = newGeneralization(fs.views, zoom)
+		fs.properties = parsePropertiesParam(c.Query("properties"))
+
+		if setCacheHeaders(c, fs) {
+			return
+		}
+		count, err := writeFeatureCollection(c, fs)
+		countFeatures(limits, count)
+		featuresReturned.WithLabelValues(metricsView(c)).Add(float64(count))
+		if err != nil {
+			//the response status was already sent. the client will get an incomplete document
+			logrus.Warnf("Error streaming collection features. err=%s", err)
+		}
+	}
+}
+
+//featuresParams are the query parameters of features requests handled by wfs-eye. Others are property filters
+var featuresParams = []string{"time", "bbox", "limit", "crs", "bbox-crs", "zoom", "properties"}
+
+//splitQueryParams separates the paging parameters, which are forwarded untouched, from the property filters
+func splitQueryParams(params url.Values) (pagingstr string, propertiesFilterStr string) {
+	for k, vs := range params {
+		for _, v := range vs {
+			if containsString(pagingParams, k) {
+				pagingstr = fmt.Sprintf("%s&%s=%s", pagingstr, url.QueryEscape(k), url.QueryEscape(v))
+			} else if !containsString(featuresParams, k) {
+				propertiesFilterStr = fmt.Sprintf("%s&%s=%s", propertiesFilterStr, url.QueryEscape(k), url.QueryEscape(v))
+			}
+		}
+	}
+	return pagingstr, propertiesFilterStr
+}
+
+//writeFeatureCollection sends features to the client as they are decoded from upstream.
+//Links and counters are written after the features because upstream may send them after the features too
+//It returns the number of features sent
+func writeFeatureCollection(c *gin.Context, fs *featureStream) (int, error) {
+	c.Header("Content-Type", "application/geo+json")
+	c.Status(http.StatusOK)
+	w := bufio.NewWriter(c.Writer)
+
+	_, err := w.WriteString(`{"type":"FeatureCollection","features":[`)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		f, err := fs.Next()
+		if err != nil {
+			return count, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+		}
+		if f == nil {
+			break
+		}
+		f = postProcessFeature(fs.views, f)
+		if f == nil {
+			continue
+		}
+		selectProperties(fs.properties, f)
+		err = fs.generalization.simplifyFeature(f)
+		if err != nil {
+			logrus.Warnf("Error simplifying feature. Sending it as it is. err=%s", err)
+		}
+		fs.crs.transformFeature(f)
+		fs.generalization.roundFeature(f)
+		data, err := marshalJSON(f)
+		if err != nil {
+			return count, err
+		}
+		if count > 0 {
+			w.WriteByte(',')
+		}
+		_, err = w.Write(data)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	w.WriteByte(']')
 
-		c.JSON(http.StatusOK, fc)
+	links := make([]Link, 0)
+	rawLinks, ok := fs.members["links"]
+	if ok {
+		err = json.Unmarshal(rawLinks, &links)
+		if err != nil {
+			logrus.Debugf("Ignoring invalid upstream links. err=%s", err)
+		}
 	}
+	links = rewritePagingLinks(links, baseURL(c), c.Request.URL)
+	data, err := marshalJSON(links)
+	if err != nil {
+		return count, err
+	}
+	fmt.Fprintf(w, `,"links":%s`, data)
+	for _, m := range []string{"numberMatched", "timeStamp"} {
+		raw, ok := fs.members[m]
+		if ok {
+			fmt.Fprintf(w, `,"%s":%s`, m, raw)
+		}
+	}
+	fmt.Fprintf(w, `,"numberReturned":%d}`, count)
+	logrus.Debugf("Features sent. feature-count=%d", count)
+	return count, w.Flush()
 }
 
-func resolveFeatureCollection(collectionName string, bboxstr string, limitstr string, timestr string, propertiesFilterStr string, previousCollectionNames []string) (*geojson.FeatureCollection, error) {
-	logrus.Debugf("resolveFeatureCollection. collectionName=%s; bboxstr=%s; limitstr=%s; timestr=%s; propertiesFilterStr=%s; previousCollectionNames=%v", collectionName, bboxstr, limitstr, timestr, propertiesFilterStr, previousCollectionNames)
+func getFeature(opt Options) func(*gin.Context) {
+	return func(c *gin.Context) {
+		collection := c.Param("collection")
+		if !authorizeCollection(c, collection) {
+			return
+		}
+		limits := limitsFor(c, collection)
+		if !checkRateLimit(c, limits) {
+			return
+		}
+		featureID := c.Param("featureId")
+		crs, ok := requestCRS(c, collection, "crs")
+		if !ok {
+			return
+		}
+		zoom, err := parseZoom(c.Query("zoom"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Invalid 'zoom'. err=%s", err)})
+			return
+		}
+
+		chain, upstreamName, err := resolveViewChain(c.Request.Context(), collection)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error getting feature. err=%s", err)})
+			logrus.Warnf("Error resolving collection %s. err=%s", collection, err)
+			return
+		}
+
+		//features outside the view restrictions are reported exactly as missing features
+		//so that consumers can't tell whether a feature id exists upstream
+		notFound := gin.H{"message": fmt.Sprintf("Feature %s not found in collection %s", featureID, collection)}
+
+		f, err := fetchUpstreamFeature(c.Request.Context(), upstreamName, featureID)
+		if err == errFeatureNotFound {
+			c.JSON(http.StatusNotFound, notFound)
+			return
+		}
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"message": fmt.Sprintf("Error getting feature. err=%s", err)})
+			logrus.Warnf("Error getting feature. err=%s", err)
+			return
+		}
+
+		//each view checks the feature as it is sent by the view it is based on, with its property names
+		for i := len(chain) - 1; i >= 0; i-- {
+			view := chain[i]
+			if !viewAllowsFeature(view, f) {
+				logrus.Debugf("Feature %s is outside view %s restrictions", featureID, *view.Name)
+				c.JSON(http.StatusNotFound, notFound)
+				return
+			}
+			f = postProcessView(view, f)
+			if f == nil {
+				c.JSON(http.StatusNotFound, notFound)
+				return
+			}
+		}
+		selectProperties(parsePropertiesParam(c.Query("properties")), f)
+		gen := newGeneralization(chain, zoom)
+		err = gen.simplifyFeature(f)
+		if err != nil {
+			logrus.Warnf("Error simplifying feature. Sending it as it is. err=%s", err)
+		}
+		crs.transformFeature(f)
+		gen.roundFeature(f)
+		c.Header("Content-Crs", crs.contentCRS())
+
+		countFeatures(limits, 1)
+		featuresReturned.WithLabelValues(metricsView(c)).Inc()
+		c.JSON(http.StatusOK, f)
+	}
+}
+
+//viewAllowsFeature checks a feature against 'maxBbox', 'maxGeometry', 'maxTimeRange' and 'defaultFilterAttr'
+//the same way features are filtered when a view is queried
+func viewAllowsFeature(view View, f *geojson.Feature) bool {
+	if view.MaxBBox != nil && len(*view.MaxBBox) == 4 {
+		if f.Geometry == nil {
+			return false
+		}
+		bb := bboxExtent(*view.MaxBBox)
+		maxBound := orb.Bound{Min: orb.Point{bb[0], bb[1]}, Max: orb.Point{bb[2], bb[3]}}
+		if !f.Geometry.Bound().Intersects(maxBound) {
+			return false
+		}
+	}
+
+	if view.MaxGeometry != nil {
+		ok, err := view.MaxGeometry.intersects(f.Geometry)
+		if err != nil {
+			logrus.Warnf("Error checking feature against view %s 'maxGeometry'. err=%s", *view.Name, err)
+			return false
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if view.MaxTimeRange != nil {
+		maxStartDate, maxEndDate, err := getDateStartEndFromString(*view.MaxTimeRange)
+		if err != nil {
+			return false
+		}
+		ft := featureTime(f, viewTimeProperty(view))
+		if ft == nil {
+			return false
+		}
+		if maxStartDate != nil && ft.Before(*maxStartDate) {
+			return false
+		}
+		if maxEndDate != nil && ft.After(*maxEndDate) {
+			return false
+		}
+	}
+
+	if view.DefaultFilterAttr != nil {
+		for k, v := range *view.DefaultFilterAttr {
+			pv, ok := propertyString(f.Properties[k])
+			if !ok || pv != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func fetchUpstreamFeature(ctx context.Context, collectionName string, featureID string) (*geojson.Feature, error) {
+	u, name := upstreamFor(collectionName)
+	q := fmt.Sprintf("%s/collections/%s/items/%s", u.url, name, url.PathEscape(featureID))
+	logrus.Debugf("WFS query: %s", q)
+	resp, err := u.Get(ctx, q)
+	if err != nil {
+		return nil, upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading WFS service response. err=%s", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errFeatureNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("WFS invocation error. status=%d. body=%s", resp.StatusCode, string(data))
+	}
+
+	f, err := geojson.UnmarshalFeature(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+	}
+	return f, nil
+}
+
+//resolveFeatureCollection merges the query parameters with each view of the chain and opens
+//a stream of the features returned by the upstream WFS. If ex is not nil, the steps are recorded
+//in it and upstream is not called. No stream is returned then
+func resolveFeatureCollection(ctx context.Context, collectionName string, bboxstr string, limitstr string, timestr string, pagingstr string, propertiesFilterStr string, previousCollectionNames []string, ex *queryExplanation) (*featureStream, error) {
+	logrus.Debugf("resolveFeatureCollection. collectionName=%s; bboxstr=%s; limitstr=%s; timestr=%s; pagingstr=%s; propertiesFilterStr=%s; previousCollectionNames=%v", collectionName, bboxstr, limitstr, timestr, pagingstr, propertiesFilterStr, previousCollectionNames)
 	if containsString(previousCollectionNames, collectionName) {
 		return nil, fmt.Errorf("View %s chain has a circular dependency", collectionName)
 	}
 	previousCollectionNames = append(previousCollectionNames, collectionName)
 
-	view, err := findView(collectionName)
+	//each step of the chain is a child of the step of the view that references it
+	ctx, span := tracer.Start(ctx, "resolve collection", trace.WithAttributes(attribute.String("wfseye.collection", collectionName)))
+	defer span.End()
+
+	view, err := findView(ctx, collectionName)
+	if err != nil && err != ErrViewNotFound {
+		return nil, err
+	}
 	if err == nil {
+		var step *explainStep
+		if ex != nil {
+			step = newExplainStep(collectionName, true, bboxstr, limitstr, timestr, propertiesFilterStr)
+		}
+
 		//ENVELOPE PARAMETERS
 
 		//BBOX
@@ -87,17 +377,43 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 			if view.DefaultBBox != nil {
 				bb := *view.DefaultBBox
 				bboxstr2 = fmt.Sprintf("%f,%f,%f,%f", bb[0], bb[1], bb[2], bb[3])
+				step.defaultApplied("defaultBbox set bbox to %s", bboxstr2)
 			}
 		}
 		if bboxstr2 != "" {
 			if view.MaxBBox != nil {
 				logrus.Debugf("intersectionBBoxStr %s %v", bboxstr2, *view.MaxBBox)
+				bboxstr1 := bboxstr2
 				bboxstr2, err = intersectionBBoxStr(bboxstr2, *view.MaxBBox)
 				if err != nil {
 					return nil, err
 				}
+				if !sameBBox(bboxstr1, bboxstr2) {
+					step.restrictionApplied("maxBbox %v clipped bbox %s to %s", *view.MaxBBox, bboxstr1, bboxstr2)
+				}
 			}
 		}
+		//upstream is queried with the envelope of 'maxGeometry'. Features outside the polygon are dropped by postProcessFeature
+		if view.MaxGeometry != nil {
+			env := view.MaxGeometry.envelope()
+			if bboxstr2 == "" {
+				bboxstr2 = fmt.Sprintf("%f,%f,%f,%f", env[0], env[1], env[2], env[3])
+				step.restrictionApplied("maxGeometry envelope set bbox to %s", bboxstr2)
+			} else {
+				bboxstr1 := bboxstr2
+				bboxstr2, err = intersectionBBoxStr(bboxstr2, env)
+				if err != nil {
+					return nil, err
+				}
+				if !sameBBox(bboxstr1, bboxstr2) {
+					step.restrictionApplied("maxGeometry envelope %v clipped bbox %s to %s", env, bboxstr1, bboxstr2)
+				}
+			}
+			step.restrictionApplied("features outside maxGeometry are dropped")
+		}
+		if view.ClipGeometries != nil && *view.ClipGeometries {
+			step.restrictionApplied("clipGeometries clips geometries to maxBbox and maxGeometry")
+		}
 
 		//LIMIT
 		limitstr2 := limitstr
@@ -109,10 +425,14 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 				}
 				limit1 := int(math.Min(float64(limit), float64(*view.MaxLimit)))
 				limitstr2 = fmt.Sprintf("%d", limit1)
+				if limit1 != limit {
+					step.restrictionApplied("maxLimit %d clipped limit %d to %d", *view.MaxLimit, limit, limit1)
+				}
 			}
 		} else {
 			if view.DefaultLimit != nil {
 				limitstr2 = fmt.Sprintf("%d", *view.DefaultLimit)
+				step.defaultApplied("defaultLimit set limit to %s", limitstr2)
 			}
 		}
 
@@ -120,6 +440,7 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 		if timestr == "" {
 			if view.DefaultTime != nil {
 				timestr = *view.DefaultTime
+				step.defaultApplied("defaultTime set time to %s", timestr)
 			}
 		}
 		dateStart, dateEnd, err := getDateStartEndFromString(timestr)
@@ -140,6 +461,7 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 			if maxStartDate != nil {
 				st2 := *maxStartDate
 				if st1.Before(st2) {
+					step.restrictionApplied("maxTimeRange %s moved time start %s to %s", *view.MaxTimeRange, st1.Format(time.RFC3339), st2.Format(time.RFC3339))
 					st1 = st2
 				}
 				sd = st1.Format(time.RFC3339)
@@ -151,6 +473,7 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 			if maxEndDate != nil {
 				st2 := *maxEndDate
 				if st1.After(st2) {
+					step.restrictionApplied("maxTimeRange %s moved time end %s to %s", *view.MaxTimeRange, st1.Format(time.RFC3339), st2.Format(time.RFC3339))
 					st1 = st2
 				}
 				ed = st1.Format(time.RFC3339)
@@ -162,21 +485,85 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 		if sd != "" || ed != "" {
 			timestr2 = fmt.Sprintf("%s/%s", sd, ed)
 		}
+		if timestr != "" && timestr2 == "" {
+			if view.MaxTimeRange == nil {
+				step.restrictionApplied("time %s was not forwarded because the view has no maxTimeRange", timestr)
+			} else {
+				step.restrictionApplied("time %s was not forwarded", timestr)
+			}
+		}
 
 		//FILTER ATTRIBUTES
+		//filters use the property names sent by this view. defaultFilterAttr uses the names it receives
+		if hasPropertyProjection(view) {
+			propertiesFilterStr1 := propertiesFilterStr
+			propertiesFilterStr, err = translatePropertiesFilter(view, propertiesFilterStr)
+			if err != nil {
+				return nil, err
+			}
+			if trimFilter(propertiesFilterStr1) != trimFilter(propertiesFilterStr) {
+				step.restrictionApplied("renameProperties changed filter %s to %s", trimFilter(propertiesFilterStr1), trimFilter(propertiesFilterStr))
+			}
+		}
 		defaultPropertiesFilterStr := ""
 		if view.DefaultFilterAttr != nil {
 			m := *view.DefaultFilterAttr
 			for k, v := range m {
-				defaultPropertiesFilterStr = fmt.Sprintf("%s&%s=%s", defaultPropertiesFilterStr, k, v)
+				defaultPropertiesFilterStr = fmt.Sprintf("%s&%s=%s", defaultPropertiesFilterStr, url.QueryEscape(k), url.QueryEscape(v))
+				step.restrictionApplied("defaultFilterAttr added filter %s=%s", k, v)
 			}
 		}
 		propertiesFilterStr2 := fmt.Sprintf("&%s&%s", propertiesFilterStr, defaultPropertiesFilterStr)
 
-		return resolveFeatureCollection(view.Collection, bboxstr2, limitstr2, timestr2, propertiesFilterStr2, previousCollectionNames)
+		//FEATURES RETURNED
+		//applied to the features sent by upstream, so they don't change the query
+		if view.IncludeProperties != nil {
+			step.restrictionApplied("includeProperties sends only properties %v", *view.IncludeProperties)
+		}
+		if view.ExcludeProperties != nil {
+			step.restrictionApplied("excludeProperties removes properties %v", *view.ExcludeProperties)
+		}
+		if view.RenameProperties != nil {
+			renames := make([]string, 0)
+			for from, to := range *view.RenameProperties {
+				renames = append(renames, fmt.Sprintf("%s to %s", from, to))
+			}
+			sort.Strings(renames)
+			step.restrictionApplied("renameProperties renames properties %s", strings.Join(renames, ", "))
+		}
+		if view.SimplifyTolerance != nil {
+			step.restrictionApplied("simplifyTolerance simplifies geometries with tolerance %v", *view.SimplifyTolerance)
+		}
+		if view.CoordinatePrecision != nil {
+			step.restrictionApplied("coordinatePrecision rounds coordinates to %d decimals", *view.CoordinatePrecision)
+		}
+		span.SetAttributes(attribute.Bool("wfseye.view", true))
+		span.SetAttributes(mergedQueryAttributes(bboxstr2, limitstr2, timestr2)...)
+
+		if ex != nil {
+			step.Output = explainParams{BBox: bboxstr2, Limit: limitstr2, Time: timestr2, Filter: trimFilter(propertiesFilterStr2)}
+			ex.Steps = append(ex.Steps, *step)
+		}
+
+		fs, err := resolveFeatureCollection(ctx, view.Collection, bboxstr2, limitstr2, timestr2, pagingstr, propertiesFilterStr2, previousCollectionNames, ex)
+		if err != nil {
+			return nil, err
+		}
+		if ex != nil {
+			return nil, nil
+		}
+		fs.views = append([]View{view}, fs.views...)
+		return fs, nil
 	}
 
 	logrus.Debugf("Fetching WFS service for collection %s", collectionName)
+	span.SetAttributes(attribute.Bool("wfseye.view", false))
+	span.SetAttributes(mergedQueryAttributes(bboxstr, limitstr, timestr)...)
+	if ex != nil {
+		step := newExplainStep(collectionName, false, bboxstr, limitstr, timestr, propertiesFilterStr)
+		step.Output = step.Input
+		ex.Steps = append(ex.Steps, *step)
+	}
 
 	if bboxstr != "" {
 		bboxstr = fmt.Sprintf("&bbox=%s", bboxstr)
@@ -187,35 +574,48 @@ func resolveFeatureCollection(collectionName string, bboxstr string, limitstr st
 	if timestr != "" {
 		timestr = fmt.Sprintf("&time=%s", timestr)
 	}
-	q := fmt.Sprintf("%s/collections/%s/items?%s%s%s%s", opt.WFSURL, collectionName, bboxstr, limitstr, timestr, propertiesFilterStr)
+	u, name := upstreamFor(collectionName)
+	q := fmt.Sprintf("%s/collections/%s/items?%s%s%s%s%s", u.url, name, bboxstr, limitstr, timestr, pagingstr, propertiesFilterStr)
 	q = strings.ReplaceAll(q, "&&&", "&")
 	q = strings.ReplaceAll(q, "&&", "&")
 	q = strings.ReplaceAll(q, "?&", "?")
-	logrus.Debugf("WFS query: %s", q)
-	resp, err := http.Get(q)
-	if err != nil {
-		return nil, fmt.Errorf("Error requesting WFS service. err=%s", err)
+	if ex != nil {
+		ex.UpstreamURL = q
+		return nil, nil
 	}
-	defer resp.Body.Close()
+	//the last name is the upstream collection. the others are the views that resolved to it
+	return fetchUpstreamFeatures(ctx, u, q, previousCollectionNames[:len(previousCollectionNames)-1])
+}
 
-	if resp.StatusCode != http.StatusOK {
-		data1, err1 := ioutil.ReadAll(resp.Body)
-		if err1 != nil {
-			return nil, fmt.Errorf("WFS invocation status != 200. status=%d. body=[failed to get contents]. err=%s", resp.StatusCode, err1)
-		}
-		return nil, fmt.Errorf("WFS invocation error. status=%d. body=%s", resp.StatusCode, string(data1))
+//mergedQueryAttributes describe the query parameters resulting from a view resolution step in its span
+func mergedQueryAttributes(bboxstr string, limitstr string, timestr string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("wfseye.bbox", bboxstr),
+		attribute.String("wfseye.limit", limitstr),
+		attribute.String("wfseye.time", timestr),
 	}
+}
 
-	var fc geojson.FeatureCollection
-	data, err0 := ioutil.ReadAll(resp.Body)
-	if err0 != nil {
-		return nil, fmt.Errorf("Error reading WFS service response. err=%s", err0)
-	}
+//resolveViewChain follows view collections down to the upstream collection the same way
+//resolveFeatureCollection does. chain is empty if collectionName is not a view
+func resolveViewChain(ctx context.Context, collectionName string) (chain []View, upstreamCollection string, err error) {
+	chain = make([]View, 0)
+	names := make([]string, 0)
+	name := collectionName
+	for {
+		if containsString(names, name) {
+			return nil, "", fmt.Errorf("View %s chain has a circular dependency", name)
+		}
+		names = append(names, name)
 
-	err = json.Unmarshal(data, &fc)
-	if err != nil {
-		return nil, fmt.Errorf("Error parsing WFS service response. err=%s", err)
+		view, err := findView(ctx, name)
+		if err == ErrViewNotFound {
+			return chain, name, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		chain = append(chain, view)
+		name = view.Collection
 	}
-	logrus.Debugf("WFS response OK. feature-count=%d. size-bytes=%d", len(fc.Features), len(data))
-	return &fc, nil
 }
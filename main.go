@@ -3,6 +3,8 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/flaviostutz/wfs-eye/handlers"
 	"github.com/sirupsen/logrus"
@@ -15,6 +17,49 @@ func main() {
 	mongoAddress0 := flag.String("mongo-address", "", "MongoDB address. Example: 'mongo', or 'mongdb://mongo1:1234/db1,mongo2:1234/db1")
 	mongoUsername0 := flag.String("mongo-username", "root", "MongoDB username")
 	mongoPassword0 := flag.String("mongo-password", "root", "MongoDB password")
+	viewStore0 := flag.String("view-store", "mongo", "Where views are persisted. 'mongo' or 'file'")
+	viewStoreFile0 := flag.String("view-store-file", "views.json", "JSON file used to persist views when 'view-store' is 'file'")
+	viewCacheSize0 := flag.Int("view-cache-size", 1000, "Max number of view definitions kept in memory. 0 disables the cache")
+	viewCacheTTL0 := flag.Duration("view-cache-ttl", 5*time.Minute, "Time a cached view definition is used before being fetched again from the view store")
+	viewNotFoundCacheSize0 := flag.Int("view-not-found-cache-size", 10000, "Max number of names known not to be views kept in memory. 0 disables the cache")
+	viewNotFoundCacheTTL0 := flag.Duration("view-not-found-cache-ttl", 30*time.Second, "Time a name known not to be a view is remembered")
+	viewWatchInterval0 := flag.Duration("view-watch-interval", 10*time.Second, "Interval for polling the view store for changes made by other replicas. 0 disables it")
+	collectionsUpstream0 := flag.Bool("collections-upstream", true, "Whether GET /collections also lists the collections of the upstream WFS server besides the views")
+	upstreamConnectTimeout0 := flag.Duration("upstream-connect-timeout", 5*time.Second, "Max time for connecting to the upstream WFS server")
+	upstreamReadTimeout0 := flag.Duration("upstream-read-timeout", 30*time.Second, "Max time waiting for the upstream WFS server to send the response headers or the next chunk of the body")
+	upstreamRetries0 := flag.Int("upstream-retries", 2, "Number of retries of upstream requests that failed with network errors or 5xx status")
+	upstreamRetryBackoff0 := flag.Duration("upstream-retry-backoff", 200*time.Millisecond, "Wait before the first retry. It doubles on each retry")
+	upstreamMaxIdleConns0 := flag.Int("upstream-max-idle-conns", 100, "Max idle connections kept open to the upstream WFS server")
+	upstreamMaxConns0 := flag.Int("upstream-max-conns", 0, "Max connections to the upstream WFS server. 0 means no limit")
+	upstreamBreakerFailures0 := flag.Int("upstream-breaker-failures", 5, "Consecutive failed upstream requests that open the circuit breaker. 0 disables it")
+	upstreamBreakerOpenTime0 := flag.Duration("upstream-breaker-open-time", 30*time.Second, "Time the circuit breaker stays open, answering 503 without calling upstream")
+	upstreamsFile0 := flag.String("upstreams-file", "", "JSON file with named upstream WFS servers that views can reference as 'upstream:collection'")
+	viewsAuth0 := flag.String("views-auth", "none", "Authentication required by the /views API. 'none', 'token', 'basic' or 'jwt'")
+	viewsReadTokens0 := flag.String("views-read-tokens", "", "Comma separated bearer tokens that can read views when 'views-auth' is 'token'")
+	viewsWriteTokens0 := flag.String("views-write-tokens", "", "Comma separated bearer tokens that can read and change views when 'views-auth' is 'token'")
+	viewsReadUsers0 := flag.String("views-read-users", "", "Comma separated 'username:password' pairs that can read views when 'views-auth' is 'basic'")
+	viewsWriteUsers0 := flag.String("views-write-users", "", "Comma separated 'username:password' pairs that can read and change views when 'views-auth' is 'basic'")
+	viewsReadRoles0 := flag.String("views-read-roles", "views-reader", "Comma separated JWT roles that can read views when 'views-auth' is 'jwt'")
+	viewsWriteRoles0 := flag.String("views-write-roles", "views-writer", "Comma separated JWT roles that can read and change views when 'views-auth' is 'jwt'")
+	jwtJWKSFile0 := flag.String("jwt-jwks-file", "", "JSON Web Key Set file with the keys used to validate JWTs")
+	jwtIssuer0 := flag.String("jwt-issuer", "", "Required 'iss' of JWTs. Not checked if empty")
+	jwtAudience0 := flag.String("jwt-audience", "", "Required 'aud' of JWTs. Not checked if empty")
+	jwtRolesClaim0 := flag.String("jwt-roles-claim", "roles", "JWT claim with the roles of the caller. Use '.' for nested claims, as in 'realm_access.roles'")
+	jwtGroupsClaim0 := flag.String("jwt-groups-claim", "groups", "JWT claim with the groups of the caller, checked against view 'access.groups'. Use '.' for nested claims")
+	publishedCollections0 := flag.String("published-collections", "*", "Comma separated upstream collections that can be queried directly, without a view. Accepts patterns like 'agency1:*'. '*' publishes all and '' none")
+	rateLimitRequests0 := flag.Int("rate-limit-requests", 0, "Max requests per minute to the features API per client (API key or IP). 0 disables it")
+	rateLimitBurst0 := flag.Int("rate-limit-burst", 0, "Max requests a client can send at once before being limited to 'rate-limit-requests'. 0 means the same as 'rate-limit-requests'")
+	rateLimitFeatures0 := flag.Int("rate-limit-features-per-day", 0, "Max features returned per client per UTC day. 0 disables it")
+	rateLimitAuthFailures0 := flag.Int("rate-limit-auth-failures", 10, "Max requests with invalid credentials to restricted views per client IP per minute. 0 disables it")
+	trustedProxies0 := flag.String("trusted-proxies", "", "Comma separated IPs or CIDRs of reverse proxies in front of wfs-eye. The client IP used for rate limits is taken from X-Forwarded-For only for requests coming from them")
+	rateLimitStore0 := flag.String("rate-limit-store", "memory", "Where rate limit counters are kept. 'memory' or 'mongo' (shared by all replicas)")
+	responseCacheSize0 := flag.Int("response-cache-size", 100, "Max MB of upstream feature responses cached in memory. 0 disables the cache")
+	responseCacheTTL0 := flag.Duration("response-cache-ttl", 0, "Time upstream feature responses are cached for views without 'cacheTTL' and for collections. 0 means only views with 'cacheTTL' are cached")
+	crs0 := flag.String("crs", "EPSG:4326,EPSG:3857", "Comma separated CRSs features can be requested in besides CRS84, as EPSG codes or OGC URIs. Supported: EPSG:4326, EPSG:3857 and WGS 84 UTM zones (EPSG:32601-32660, EPSG:32701-32760)")
+	otlpEndpoint0 := flag.String("otlp-endpoint", "", "host:port of an OpenTelemetry collector receiving traces with OTLP/HTTP. Empty disables tracing")
+	otlpInsecure0 := flag.Bool("otlp-insecure", false, "Send traces to the OTLP collector with plain HTTP instead of HTTPS")
+	traceSampleRatio0 := flag.Float64("trace-sample-ratio", 1, "Fraction of the traces started by wfs-eye that are exported. Requests with a trace context follow the sampling decision of the caller")
+	traceServiceName0 := flag.String("trace-service-name", "wfs-eye", "Service name reported in traces")
 	flag.Parse()
 
 	switch *logLevel {
@@ -39,9 +84,67 @@ func main() {
 		MongoAddress:  *mongoAddress0,
 		MongoUsername: *mongoUsername0,
 		MongoPassword: *mongoPassword0,
+		Upstream: handlers.UpstreamOptions{
+			ConnectTimeout:  *upstreamConnectTimeout0,
+			ReadTimeout:     *upstreamReadTimeout0,
+			Retries:         *upstreamRetries0,
+			RetryBackoff:    *upstreamRetryBackoff0,
+			MaxIdleConns:    *upstreamMaxIdleConns0,
+			MaxConns:        *upstreamMaxConns0,
+			BreakerFailures: *upstreamBreakerFailures0,
+			BreakerOpenTime: *upstreamBreakerOpenTime0,
+		},
+		UpstreamsFile: *upstreamsFile0,
+		ViewStoreType: *viewStore0,
+		ViewStoreFile: *viewStoreFile0,
+
+		ViewCacheSize:         *viewCacheSize0,
+		ViewCacheTTL:          *viewCacheTTL0,
+		ViewNotFoundCacheSize: *viewNotFoundCacheSize0,
+		ViewNotFoundCacheTTL:  *viewNotFoundCacheTTL0,
+		ViewWatchInterval:     *viewWatchInterval0,
+
+		CollectionsUpstream:  *collectionsUpstream0,
+		PublishedCollections: splitList(*publishedCollections0),
+		CRS:                  splitList(*crs0),
+		ResponseCacheSize:    *responseCacheSize0 * 1024 * 1024,
+		ResponseCacheTTL:     *responseCacheTTL0,
+
+		RateLimit: handlers.RateLimitOptions{
+			RequestsPerMinute:     *rateLimitRequests0,
+			Burst:                 *rateLimitBurst0,
+			FeaturesPerDay:        *rateLimitFeatures0,
+			AuthFailuresPerMinute: *rateLimitAuthFailures0,
+			Store:                 *rateLimitStore0,
+			TrustedProxies:        splitList(*trustedProxies0),
+		},
+
+		ViewsAuth: handlers.ViewsAuthOptions{
+			Type:        *viewsAuth0,
+			ReadTokens:  splitList(*viewsReadTokens0),
+			WriteTokens: splitList(*viewsWriteTokens0),
+			ReadUsers:   splitList(*viewsReadUsers0),
+			WriteUsers:  splitList(*viewsWriteUsers0),
+			ReadRoles:   splitList(*viewsReadRoles0),
+			WriteRoles:  splitList(*viewsWriteRoles0),
+		},
+		JWT: handlers.JWTOptions{
+			JWKSFile:    *jwtJWKSFile0,
+			Issuer:      *jwtIssuer0,
+			Audience:    *jwtAudience0,
+			RolesClaim:  *jwtRolesClaim0,
+			GroupsClaim: *jwtGroupsClaim0,
+		},
+
+		Tracing: handlers.TracingOptions{
+			OTLPEndpoint: *otlpEndpoint0,
+			OTLPInsecure: *otlpInsecure0,
+			SampleRatio:  *traceSampleRatio0,
+			ServiceName:  *traceServiceName0,
+		},
 	}
 
-	if opt.MongoAddress == "" {
+	if (opt.ViewStoreType == "mongo" || opt.RateLimit.Store == "mongo") && opt.MongoAddress == "" {
 		logrus.Errorf("'mongo-address' parameter is required")
 		os.Exit(1)
 	}
@@ -62,3 +165,15 @@ func main() {
 	}
 
 }
+
+//splitList splits a comma separated flag value ignoring empty items
+func splitList(s string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
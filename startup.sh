@@ -3,8 +3,51 @@
 wfs-eye \
   --loglevel="$LOG_LEVEL" \
   --wfs-url="$WFS3_API_URL" \
+  --upstream-connect-timeout="$UPSTREAM_CONNECT_TIMEOUT" \
+  --upstream-read-timeout="$UPSTREAM_READ_TIMEOUT" \
+  --upstream-retries="$UPSTREAM_RETRIES" \
+  --upstream-retry-backoff="$UPSTREAM_RETRY_BACKOFF" \
+  --upstream-max-idle-conns="$UPSTREAM_MAX_IDLE_CONNS" \
+  --upstream-max-conns="$UPSTREAM_MAX_CONNS" \
+  --upstream-breaker-failures="$UPSTREAM_BREAKER_FAILURES" \
+  --upstream-breaker-open-time="$UPSTREAM_BREAKER_OPEN_TIME" \
+  --upstreams-file="$UPSTREAMS_FILE" \
   --mongo-dbname="$MONGO_DBNAME" \
   --mongo-address="$MONGO_ADDRESS" \
   --mongo-username=$MONGO_USERNAME \
-  --mongo-password=$MONGO_PASSWORD
+  --mongo-password=$MONGO_PASSWORD \
+  --view-store="$VIEW_STORE" \
+  --view-store-file="$VIEW_STORE_FILE" \
+  --view-cache-size="$VIEW_CACHE_SIZE" \
+  --view-cache-ttl="$VIEW_CACHE_TTL" \
+  --view-not-found-cache-size="$VIEW_NOT_FOUND_CACHE_SIZE" \
+  --view-not-found-cache-ttl="$VIEW_NOT_FOUND_CACHE_TTL" \
+  --view-watch-interval="$VIEW_WATCH_INTERVAL" \
+  --collections-upstream="$COLLECTIONS_UPSTREAM" \
+  --published-collections="$PUBLISHED_COLLECTIONS" \
+  --response-cache-size="$RESPONSE_CACHE_SIZE" \
+  --response-cache-ttl="$RESPONSE_CACHE_TTL" \
+  --rate-limit-requests="$RATE_LIMIT_REQUESTS" \
+  --rate-limit-burst="$RATE_LIMIT_BURST" \
+  --rate-limit-features-per-day="$RATE_LIMIT_FEATURES_PER_DAY" \
+  --rate-limit-auth-failures="$RATE_LIMIT_AUTH_FAILURES" \
+  --rate-limit-store="$RATE_LIMIT_STORE" \
+  --trusted-proxies="$TRUSTED_PROXIES" \
+  --views-auth="$VIEWS_AUTH" \
+  --views-read-tokens="$VIEWS_READ_TOKENS" \
+  --views-write-tokens="$VIEWS_WRITE_TOKENS" \
+  --views-read-users="$VIEWS_READ_USERS" \
+  --views-write-users="$VIEWS_WRITE_USERS" \
+  --views-read-roles="$VIEWS_READ_ROLES" \
+  --views-write-roles="$VIEWS_WRITE_ROLES" \
+  --jwt-jwks-file="$JWT_JWKS_FILE" \
+  --jwt-issuer="$JWT_ISSUER" \
+  --jwt-audience="$JWT_AUDIENCE" \
+  --jwt-roles-claim="$JWT_ROLES_CLAIM" \
+  --jwt-groups-claim="$JWT_GROUPS_CLAIM" \
+  --otlp-endpoint="$OTLP_ENDPOINT" \
+  --otlp-insecure="$OTLP_INSECURE" \
+  --trace-sample-ratio="$TRACE_SAMPLE_RATIO" \
+  --trace-service-name="$TRACE_SERVICE_NAME" \
+  --crs="$CRS"
 